@@ -0,0 +1,482 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// These are the access log formats.
+const (
+	// AccessLogLogrus logs each request as a logrus entry with the message
+	// "REQ", the way LogMW always has.
+	AccessLogLogrus = "logrus"
+	// AccessLogCombined is the Apache combined log format.
+	AccessLogCombined = "combined"
+	// AccessLogJSON writes a JSON object per line.
+	AccessLogJSON = "json"
+	// AccessLogECS writes a JSON object per line with Elastic Common
+	// Schema field names, which OpenTelemetry's HTTP conventions share.
+	AccessLogECS = "ecs"
+)
+
+// AccessLogFields are the fields of the json format, in the order that
+// they are listed by default.
+var AccessLogFields = []string{
+	"time", "host", "remote_addr", "method", "uri", "route", "proto",
+	"status", "bytes", "duration_ms", "user_agent", "referer", "request_id",
+	"request_headers", "response_headers", "query", "level", "sample_rate",
+}
+
+// redactedHeaders are never logged in full, even if they are asked for.
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	APIKeyHeader:          true,
+	HMACSignatureHeader:   true,
+	"Proxy-Authorization": true,
+}
+
+// AccessLog writes a line (or a logrus entry) for each request.
+type AccessLog struct {
+	// Format is one of the AccessLog* formats; it defaults to logrus.
+	Format string
+	// Logger receives entries in the logrus format.
+	Logger log.FieldLogger
+	// Out receives lines in the other formats; it defaults to stdout.
+	Out io.Writer
+	// Fields limits the fields in the json format.
+	Fields []string
+	// RequestHeaders, ResponseHeaders, and QueryParams name headers and
+	// query parameters to include. Credentials are always redacted. Other
+	// query parameters are left out, even from the URI, since they can
+	// carry tokens.
+	RequestHeaders  []string
+	ResponseHeaders []string
+	QueryParams     []string
+	// Exclude lists paths that aren't logged, along with everything under
+	// them; /health excludes /health/live too.
+	Exclude []string
+	// Sampling, if set, decides which requests are logged and at what
+	// level; otherwise all of them are, at Info. The combined format has
+	// no room for the level or sample rate.
+	Sampling *SamplePolicy
+
+	mutex sync.Mutex
+}
+
+// accessEntry is what is known about a request once it is done.
+type accessEntry struct {
+	start       time.Time
+	duration    time.Duration
+	r           *http.Request
+	status      int
+	length      int
+	reqHeaders  map[string]string
+	respHeaders map[string]string
+	query       map[string]string
+	// level and rate are set when a SamplePolicy is in use; rate is 0
+	// otherwise.
+	level log.Level
+	rate  float64
+}
+
+// excluded reports whether the request's path is excluded from the log.
+func (al *AccessLog) excluded(r *http.Request) bool {
+	for _, p := range al.Exclude {
+		p = strings.TrimSuffix(p, "/")
+		if r.URL.Path == p || strings.HasPrefix(r.URL.Path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// pickHeaders returns the named headers that are present in h.
+func pickHeaders(h http.Header, names []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	picked := make(map[string]string)
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		v, ok := h[name]
+		if !ok {
+			continue
+		}
+		if redactedHeaders[name] {
+			picked[name] = redacted
+		} else {
+			picked[name] = strings.Join(v, ", ")
+		}
+	}
+	return picked
+}
+
+// pickQuery returns the named query parameters that are present in r.
+func pickQuery(r *http.Request, names []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	q := r.URL.Query()
+	picked := make(map[string]string)
+	for _, name := range names {
+		if v, ok := q[name]; ok {
+			picked[name] = strings.Join(v, ",")
+		}
+	}
+	return picked
+}
+
+// uri returns the request's path, with only the query parameters that are
+// to be logged.
+func (e *accessEntry) uri() string {
+	if q := e.queryString(); q != "" {
+		return e.r.URL.EscapedPath() + "?" + q
+	}
+	return e.r.URL.EscapedPath()
+}
+
+// queryString encodes the query parameters that are to be logged.
+func (e *accessEntry) queryString() string {
+	q := make(url.Values, len(e.query))
+	for k, v := range e.query {
+		q.Set(k, v)
+	}
+	return q.Encode()
+}
+
+// logLevel returns the level that the entry is logged at.
+func (e *accessEntry) logLevel() log.Level {
+	if e.rate == 0 {
+		return log.InfoLevel
+	}
+	return e.level
+}
+
+// statusCode returns the status sent, which is 200 if nothing called
+// WriteHeader.
+func (e *accessEntry) statusCode() int {
+	if e.status == 0 {
+		return http.StatusOK
+	}
+	return e.status
+}
+
+// logrusFields returns the fields in the logrus format.
+func (e *accessEntry) logrusFields() log.Fields {
+	fields := log.Fields{
+		"host":       e.r.Host,
+		"remoteAddr": e.r.RemoteAddr,
+		"method":     e.r.Method,
+		"uri":        e.uri(),
+		"code":       e.status,
+		"len":        e.length,
+		"ua":         e.r.Header.Get("User-Agent"),
+		"took":       e.duration,
+	}
+	if id := RequestID(e.r); id != "" {
+		fields["requestID"] = id
+	}
+	if len(e.reqHeaders) != 0 {
+		fields["reqHeaders"] = e.reqHeaders
+	}
+	if len(e.respHeaders) != 0 {
+		fields["respHeaders"] = e.respHeaders
+	}
+	if len(e.query) != 0 {
+		fields["query"] = e.query
+	}
+	if e.rate != 0 {
+		fields["sampleRate"] = e.rate
+	}
+	return fields
+}
+
+// combined formats the entry in the Apache combined log format.
+func (e *accessEntry) combined() string {
+	host, _, err := net.SplitHostPort(e.r.RemoteAddr)
+	if err != nil {
+		host = e.r.RemoteAddr
+	}
+	size := "-"
+	if e.length != 0 {
+		size = fmt.Sprint(e.length)
+	}
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s %q %q\n",
+		host, e.start.Format("02/Jan/2006:15:04:05 -0700"),
+		e.r.Method, e.uri(), e.r.Proto, e.statusCode(), size,
+		orDash(e.r.Referer()), orDash(e.r.UserAgent()))
+}
+
+// jsonFields returns the fields in the json format, limited to fields if
+// it isn't empty.
+func (e *accessEntry) jsonFields(fields []string) map[string]interface{} {
+	all := map[string]interface{}{
+		"time":        e.start.UTC().Format(time.RFC3339Nano),
+		"host":        e.r.Host,
+		"remote_addr": e.r.RemoteAddr,
+		"method":      e.r.Method,
+		"uri":         e.uri(),
+		"route":       RoutePattern(e.r),
+		"proto":       e.r.Proto,
+		"status":      e.statusCode(),
+		"bytes":       e.length,
+		"duration_ms": float64(e.duration) / float64(time.Millisecond),
+		"user_agent":  e.r.UserAgent(),
+		"referer":     e.r.Referer(),
+		"request_id":  RequestID(e.r),
+	}
+	if len(e.reqHeaders) != 0 {
+		all["request_headers"] = e.reqHeaders
+	}
+	if len(e.respHeaders) != 0 {
+		all["response_headers"] = e.respHeaders
+	}
+	if len(e.query) != 0 {
+		all["query"] = e.query
+	}
+	if e.rate != 0 {
+		all["level"] = e.level.String()
+		all["sample_rate"] = e.rate
+	}
+	if len(fields) == 0 {
+		return all
+	}
+	picked := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			picked[f] = v
+		}
+	}
+	return picked
+}
+
+// ecsFields returns the fields with ECS names, flattened with dots.
+// Headers follow the OpenTelemetry convention of lowercase names.
+func (e *accessEntry) ecsFields() map[string]interface{} {
+	host, port, _ := net.SplitHostPort(e.r.RemoteAddr)
+	fields := map[string]interface{}{
+		"@timestamp":                e.start.UTC().Format(time.RFC3339Nano),
+		"ecs.version":               "8.11.0",
+		"event.kind":                "event",
+		"event.category":            "web",
+		"event.duration":            e.duration.Nanoseconds(),
+		"client.address":            host,
+		"source.address":            host,
+		"url.original":              e.uri(),
+		"url.path":                  e.r.URL.Path,
+		"url.domain":                e.r.Host,
+		"http.version":              strings.TrimPrefix(e.r.Proto, "HTTP/"),
+		"http.request.method":       e.r.Method,
+		"http.response.status_code": e.statusCode(),
+		"http.response.body.bytes":  e.length,
+		"user_agent.original":       e.r.UserAgent(),
+	}
+	if port != "" {
+		fields["client.port"] = port
+	}
+	if q := e.queryString(); q != "" {
+		fields["url.query"] = q
+	}
+	if ref := e.r.Referer(); ref != "" {
+		fields["http.request.referrer"] = ref
+	}
+	if id := RequestID(e.r); id != "" {
+		fields["http.request.id"] = id
+	}
+	if route := RoutePattern(e.r); route != "" {
+		fields["http.route"] = route
+	}
+	for k, v := range e.reqHeaders {
+		fields["http.request.header."+strings.ToLower(k)] = v
+	}
+	for k, v := range e.respHeaders {
+		fields["http.response.header."+strings.ToLower(k)] = v
+	}
+	for k, v := range e.query {
+		fields["url.query_params."+k] = v
+	}
+	if e.rate != 0 {
+		fields["log.level"] = e.level.String()
+		fields["event.sample_rate"] = e.rate
+	}
+	return fields
+}
+
+// jsonLine encodes fields as a line of JSON, leaving characters like &
+// in URIs alone.
+func jsonLine(fields map[string]interface{}) []byte {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(fields)
+	return buf.Bytes()
+}
+
+// levelWriter is an access log output that records the level of each
+// line, like syslog.
+type levelWriter interface {
+	WriteLevel(level log.Level, line []byte) error
+}
+
+// write logs the entry in the configured format.
+func (al *AccessLog) write(e *accessEntry) {
+	var line []byte
+	switch al.Format {
+	case AccessLogCombined:
+		line = []byte(e.combined())
+	case AccessLogJSON:
+		line = jsonLine(e.jsonFields(al.Fields))
+	case AccessLogECS:
+		line = jsonLine(e.ecsFields())
+	default:
+		logger := al.Logger
+		if logger == nil {
+			logger = log.StandardLogger()
+		}
+		logger.WithFields(e.logrusFields()).Log(e.logLevel(), "REQ")
+		return
+	}
+	out := al.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+	if lw, ok := out.(levelWriter); ok {
+		lw.WriteLevel(e.logLevel(), line)
+		return
+	}
+	out.Write(line)
+}
+
+// AccessLogMW wraps a handler and writes an access log entry for each
+// request that isn't excluded, subject to the sampling policy.
+func AccessLogMW(al *AccessLog, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if al.excluded(r) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		lw := LogWriter{ResponseWriter: w}
+		handler.ServeHTTP(&lw, r)
+		e := &accessEntry{
+			start:    start,
+			duration: time.Since(start),
+			r:        r,
+			status:   lw.status,
+			length:   lw.length,
+		}
+		if al.Sampling != nil {
+			var ok bool
+			ok, e.level, e.rate = al.Sampling.decide(r, e.statusCode(), e.duration)
+			if !ok {
+				return
+			}
+		}
+		e.reqHeaders = pickHeaders(r.Header, al.RequestHeaders)
+		e.respHeaders = pickHeaders(lw.Header(), al.ResponseHeaders)
+		e.query = pickQuery(r, al.QueryParams)
+		al.write(e)
+	}
+}
+
+// addAccessLogConfig adds the config items used by AccessLogFromConfig.
+func addAccessLogConfig(cf *Config) {
+	cf.AddEnum("ACCESS_LOG_FORMAT", AccessLogLogrus, AccessLogLogrus, AccessLogCombined, AccessLogJSON, AccessLogECS)
+	cf.Describe("ACCESS_LOG_FORMAT", "format of the access log")
+	cf.AddString("ACCESS_LOG_OUTPUT", "stdout", "where to write the access log, unless it's in the logrus format: stdout, stderr, file:PATH, or syslog[:TAG]")
+	cf.AddByteSize("ACCESS_LOG_MAX_SIZE", "100MiB", "size at which a file access log is rotated")
+	cf.AddInt("ACCESS_LOG_MAX_BACKUPS", 5, "number of rotated access log files to keep")
+	cf.AddStringArray("ACCESS_LOG_FIELDS")
+	cf.Describe("ACCESS_LOG_FIELDS", "fields to include in the json access log (all if empty)")
+	cf.AddStringArray("ACCESS_LOG_REQUEST_HEADERS")
+	cf.Describe("ACCESS_LOG_REQUEST_HEADERS", "request headers to include in the access log")
+	cf.AddStringArray("ACCESS_LOG_RESPONSE_HEADERS")
+	cf.Describe("ACCESS_LOG_RESPONSE_HEADERS", "response headers to include in the access log")
+	cf.AddStringArray("ACCESS_LOG_QUERY_PARAMS")
+	cf.Describe("ACCESS_LOG_QUERY_PARAMS", "query parameters to include in the access log (the rest are left out of the URI)")
+	cf.AddStringArray("ACCESS_LOG_EXCLUDE")
+	cf.Describe("ACCESS_LOG_EXCLUDE", "paths (and everything under them) to leave out of the access log")
+	addSamplingConfig(cf)
+}
+
+// accessLogOutput opens an access log destination.
+func accessLogOutput(dest string, maxSize ByteSize, maxBackups int) (io.Writer, error) {
+	switch {
+	case dest == "" || dest == "stdout":
+		return os.Stdout, nil
+	case dest == "stderr":
+		return os.Stderr, nil
+	case strings.HasPrefix(dest, "file:"):
+		return OpenRotatingFile(strings.TrimPrefix(dest, "file:"), maxSize, maxBackups)
+	case dest == "syslog" || strings.HasPrefix(dest, "syslog:"):
+		return openSyslog(strings.TrimPrefix(strings.TrimPrefix(dest, "syslog"), ":"))
+	}
+	return nil, fmt.Errorf("ACCESS_LOG_OUTPUT: unknown destination %q", dest)
+}
+
+// AccessLogFromConfig creates an AccessLog from the ACCESS_LOG_* items and
+// SLOW_REQUEST_THRESHOLD, logging to logger in the logrus format.
+func AccessLogFromConfig(cf *Config, logger log.FieldLogger) (*AccessLog, error) {
+	al := &AccessLog{
+		Format:          cf.GetEnum("ACCESS_LOG_FORMAT"),
+		Logger:          logger,
+		Fields:          cf.GetStringArray("ACCESS_LOG_FIELDS"),
+		RequestHeaders:  cf.GetStringArray("ACCESS_LOG_REQUEST_HEADERS"),
+		ResponseHeaders: cf.GetStringArray("ACCESS_LOG_RESPONSE_HEADERS"),
+		QueryParams:     cf.GetStringArray("ACCESS_LOG_QUERY_PARAMS"),
+		Exclude:         cf.GetStringArray("ACCESS_LOG_EXCLUDE"),
+	}
+	sampling, err := SamplePolicyFromConfig(cf)
+	if err != nil {
+		return nil, err
+	}
+	al.Sampling = sampling
+	for _, f := range al.Fields {
+		known := false
+		for _, k := range AccessLogFields {
+			known = known || f == k
+		}
+		if !known {
+			return nil, fmt.Errorf("ACCESS_LOG_FIELDS: unknown field %s", f)
+		}
+	}
+	if al.Format != AccessLogLogrus && al.Format != "" {
+		out, err := accessLogOutput(cf.GetString("ACCESS_LOG_OUTPUT"), cf.GetByteSize("ACCESS_LOG_MAX_SIZE"), cf.GetInt("ACCESS_LOG_MAX_BACKUPS"))
+		if err != nil {
+			return nil, err
+		}
+		al.Out = out
+	}
+	return al, nil
+}
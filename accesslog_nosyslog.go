@@ -0,0 +1,23 @@
+//go:build windows || plan9
+
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"errors"
+	"io"
+)
+
+// openSyslog fails, since log/syslog isn't available on this platform.
+func openSyslog(tag string) (io.Writer, error) {
+	return nil, errors.New("ACCESS_LOG_OUTPUT: syslog is not supported on this platform")
+}
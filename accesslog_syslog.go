@@ -0,0 +1,53 @@
+//go:build !windows && !plan9
+
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"io"
+	"log/syslog"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// syslogWriter writes access log lines to syslog at the priority that
+// matches their level.
+type syslogWriter struct {
+	*syslog.Writer
+}
+
+// openSyslog connects to the local syslog daemon, logging with tag.
+func openSyslog(tag string) (io.Writer, error) {
+	// an empty network and address mean the local syslog socket
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_LOCAL0, tag)
+	if err != nil {
+		return nil, err
+	}
+	return syslogWriter{w}, nil
+}
+
+// WriteLevel implements levelWriter.
+func (sw syslogWriter) WriteLevel(level log.Level, line []byte) error {
+	msg := string(line)
+	switch level {
+	case log.PanicLevel, log.FatalLevel:
+		return sw.Crit(msg)
+	case log.ErrorLevel:
+		return sw.Err(msg)
+	case log.WarnLevel:
+		return sw.Warning(msg)
+	case log.InfoLevel:
+		return sw.Info(msg)
+	default:
+		return sw.Debug(msg)
+	}
+}
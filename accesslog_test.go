@@ -0,0 +1,205 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// logRequest serves one request through AccessLogMW and RouteMW, and
+// returns what was logged.
+func logRequest(t *testing.T, al *AccessLog, status int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if al.Format == AccessLogLogrus {
+		logger := logTo(&buf)
+		al.Logger = logger
+	} else {
+		al.Out = &buf
+	}
+	rt := NewRouteTable(nil)
+	rt.Add("GET", "/count/:first/:last")
+	handler := RequestIDMW(quietLogger(), RouteMW(rt, AccessLogMW(al, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=s3cret")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(status)
+		w.Write([]byte("hello"))
+	}))))
+	r := httptest.NewRequest("GET", "/count/1/10?token=s3cret&page=2", nil)
+	r.RemoteAddr = "192.0.2.1:5555"
+	r.Header.Set("Authorization", "Bearer s3cret")
+	r.Header.Set("X-Trace", "abc")
+	r.Header.Set("User-Agent", "test/1.0")
+	r.Header.Set(RequestIDHeader, "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	out := buf.String()
+	if strings.Contains(out, "s3cret") {
+		t.Errorf("a credential was logged: %s", out)
+	}
+	return out
+}
+
+// newTestAccessLog returns an AccessLog that picks some of everything.
+func newTestAccessLog(format string) *AccessLog {
+	return &AccessLog{
+		Format:          format,
+		RequestHeaders:  []string{"Authorization", "X-Trace"},
+		ResponseHeaders: []string{"Set-Cookie", "Content-Type"},
+		QueryParams:     []string{"page"},
+	}
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	line := logRequest(t, newTestAccessLog(AccessLogCombined), http.StatusOK)
+	re := regexp.MustCompile(`^192\.0\.2\.1 - - \[\d\d/\w{3}/\d{4}:\d\d:\d\d:\d\d [-+]\d{4}\] "GET /count/1/10\?page=2 HTTP/1\.1" 200 5 "-" "test/1\.0"\n$`)
+	if !re.MatchString(line) {
+		t.Errorf("got %q", line)
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(logRequest(t, newTestAccessLog(AccessLogJSON), http.StatusTeapot)), &entry); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"method":      "GET",
+		"uri":         "/count/1/10?page=2",
+		"route":       "/count/:first/:last",
+		"status":      float64(http.StatusTeapot),
+		"bytes":       float64(5),
+		"remote_addr": "192.0.2.1:5555",
+		"request_id":  "req-1",
+		"user_agent":  "test/1.0",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("%s = %v, want %v", k, entry[k], v)
+		}
+	}
+	reqHeaders, _ := entry["request_headers"].(map[string]interface{})
+	if reqHeaders["Authorization"] != redacted || reqHeaders["X-Trace"] != "abc" {
+		t.Errorf("request headers %v", reqHeaders)
+	}
+	respHeaders, _ := entry["response_headers"].(map[string]interface{})
+	if respHeaders["Set-Cookie"] != redacted || respHeaders["Content-Type"] != "text/plain" {
+		t.Errorf("response headers %v", respHeaders)
+	}
+	if query, _ := entry["query"].(map[string]interface{}); len(query) != 1 || query["page"] != "2" {
+		t.Errorf("query %v", entry["query"])
+	}
+	if _, ok := entry["sample_rate"]; ok {
+		t.Error("sample_rate without a sampling policy")
+	}
+
+	// the fields can be limited
+	al := newTestAccessLog(AccessLogJSON)
+	al.Fields = []string{"method", "status"}
+	entry = nil
+	if err := json.Unmarshal([]byte(logRequest(t, al, http.StatusOK)), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if len(entry) != 2 || entry["method"] != "GET" || entry["status"] != float64(200) {
+		t.Errorf("got %v", entry)
+	}
+}
+
+func TestAccessLogECS(t *testing.T) {
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(logRequest(t, newTestAccessLog(AccessLogECS), http.StatusOK)), &entry); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"http.request.method":               "GET",
+		"url.original":                      "/count/1/10?page=2",
+		"url.path":                          "/count/1/10",
+		"url.query":                         "page=2",
+		"url.query_params.page":             "2",
+		"http.route":                        "/count/:first/:last",
+		"http.response.status_code":         float64(200),
+		"http.response.body.bytes":          float64(5),
+		"client.address":                    "192.0.2.1",
+		"client.port":                       "5555",
+		"http.request.id":                   "req-1",
+		"http.request.header.authorization": redacted,
+		"http.request.header.x-trace":       "abc",
+		"http.response.header.set-cookie":   redacted,
+		"http.response.header.content-type": "text/plain",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("%s = %v, want %v", k, entry[k], v)
+		}
+	}
+}
+
+func TestAccessLogLogrus(t *testing.T) {
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(logRequest(t, newTestAccessLog(AccessLogLogrus), http.StatusOK)), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "REQ" || entry["uri"] != "/count/1/10?page=2" || entry["requestID"] != "req-1" {
+		t.Errorf("got %v", entry)
+	}
+}
+
+// levelRecorder is a levelWriter that records the levels it's given.
+type levelRecorder struct {
+	bytes.Buffer
+	levels []log.Level
+}
+
+func (lr *levelRecorder) WriteLevel(level log.Level, line []byte) error {
+	lr.levels = append(lr.levels, level)
+	_, err := lr.Write(line)
+	return err
+}
+
+func TestAccessLogLevels(t *testing.T) {
+	lr := &levelRecorder{}
+	al := &AccessLog{Format: AccessLogJSON, Out: lr, Sampling: &SamplePolicy{Percent: 100}}
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		AccessLogMW(al, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	if len(lr.levels) != 2 || lr.levels[0] != log.InfoLevel || lr.levels[1] != log.ErrorLevel {
+		t.Errorf("got levels %v", lr.levels)
+	}
+}
+
+func TestAccessLogExclude(t *testing.T) {
+	var buf bytes.Buffer
+	al := &AccessLog{Format: AccessLogCombined, Out: &buf, Exclude: []string{"/health/", "/metrics"}}
+	handler := AccessLogMW(al, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for path, logged := range map[string]bool{
+		"/health":       false,
+		"/health/live":  false,
+		"/metrics":      false,
+		"/healthz":      true,
+		"/metrics2":     true,
+		"/count/health": true,
+	} {
+		buf.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		if (buf.Len() != 0) != logged {
+			t.Errorf("%s: logged %q", path, buf.String())
+		}
+	}
+}
@@ -13,29 +13,60 @@ package rest
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
 // Config maintains a map of configuration values. They can be read from
-// the environment and/or the command line (command line overrides environment).
+// a config file, the environment, and/or the command line (command line
+// overrides environment, which overrides the config file).
 // The names of configuration items are strings containing letters, numbers,
-// underscores, and hyphens. They can be any of int, string, []string, duration,
-// or bool.
+// underscores, and hyphens. They can be any of int, int64, uint, float, string,
+// []string, duration, bool, secret, url, enum, bytesize, map, or path.
 // Although there are lots of configuration packages out there, there didn't
 // seem to be one that was both simple and would allow the standard service
 // to define part of the configuration, and client packages to define the
 // rest.
 type Config map[string]ConfigItem
 
+// ConfigSource records where a config item's value came from.
+type ConfigSource string
+
+// These are the places a config value can come from, in priority order.
+const (
+	SourceDefault ConfigSource = "default"
+	SourceFile    ConfigSource = "file"
+	SourceEnv     ConfigSource = "env"
+	SourceFlag    ConfigSource = "flag"
+)
+
 // ConfigItem is one element of the Config map
 type ConfigItem struct {
 	Name    string
 	Type    string
 	Value   interface{}
 	Default interface{}
+	Help    string
+	Secret  bool
+	// Allowed lists the valid values of an enum item, and MustExist
+	// requires the value of a path item to exist.
+	Allowed   []string
+	MustExist bool
+	// Source is where Value came from, and Raw is the string it was parsed
+	// from; an item that was never set has a Source of "" (the default).
+	// Raw is never kept for secret items.
+	Source ConfigSource
+	Raw    string
+
+	// struct fields that receive the value after Load (see Bind)
+	targets []reflect.Value
+	// functions to call when Reload changes the value (see OnChange)
+	watchers []func(old, new interface{})
 }
 
 func clean(s string) string {
@@ -43,48 +74,69 @@ func clean(s string) string {
 }
 
 // AddInt adds a config element that is an integer with its default.
-func (cf *Config) AddInt(name string, def int) {
-	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "int", Default: def}
+// An optional description is shown in the --help output.
+func (cf *Config) AddInt(name string, def int, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "int", Default: def, Help: joinHelp(help)}
 }
 
 // AddString adds a config element that is a string with its default.
-func (cf *Config) AddString(name string, def string) {
-	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "string", Default: def}
+// An optional description is shown in the --help output.
+func (cf *Config) AddString(name string, def string, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "string", Default: def, Help: joinHelp(help)}
 }
 
 // AddStringArray adds a config element that is an array of strings with an arbitrary
-// list of default values
+// list of default values. Since the defaults are variadic, use Describe to
+// give it a description.
 func (cf *Config) AddStringArray(name string, defaults ...string) {
 	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "[]string", Default: defaults}
 }
 
 // AddFlag adds a config element that is a boolean flag with a default value.
-func (cf *Config) AddFlag(name string, def bool) {
-	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "bool", Default: def}
+// An optional description is shown in the --help output.
+func (cf *Config) AddFlag(name string, def bool, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "bool", Default: def, Help: joinHelp(help)}
 }
 
 // AddDuration adds a config element that is a duration with a default value.
 // The duration is specified as a string and is returned as a time.Duration.
-func (cf *Config) AddDuration(name string, def string) {
-	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "duration", Default: def}
+// An optional description is shown in the --help output.
+func (cf *Config) AddDuration(name string, def string, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "duration", Default: def, Help: joinHelp(help)}
 }
 
 // AddRequiredInt adds a config element that is an integer with no default value
 // (it must be specified or the server will fail to start).
-func (cf *Config) AddRequiredInt(name string) {
-	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "int", Default: nil}
+// An optional description is shown in the --help output.
+func (cf *Config) AddRequiredInt(name string, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "int", Default: nil, Help: joinHelp(help)}
 }
 
 // AddRequiredString adds a config element that is a string with no default value
 // (it must be specified or the server will fail to start).
-func (cf *Config) AddRequiredString(name string) {
-	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "string", Default: nil}
+// An optional description is shown in the --help output.
+func (cf *Config) AddRequiredString(name string, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "string", Default: nil, Help: joinHelp(help)}
 }
 
 // AddRequiredFlag adds a config element that is a boolean with no default value
 // (it must be specified or the server will fail to start).
-func (cf *Config) AddRequiredFlag(name string) {
-	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "bool", Default: nil}
+// An optional description is shown in the --help output.
+func (cf *Config) AddRequiredFlag(name string, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "bool", Default: nil, Help: joinHelp(help)}
+}
+
+// Describe sets the description of an existing config item, which is
+// shown in the --help output.
+func (cf *Config) Describe(name string, help string) {
+	name = clean(name)
+	ag := (*cf)[name]
+	ag.Help = help
+	(*cf)[name] = ag
+}
+
+func joinHelp(help []string) string {
+	return strings.Join(help, " ")
 }
 
 // SetDefault allows setting a default value for a name after it has been
@@ -98,34 +150,83 @@ func (cf *Config) SetDefault(name string, def interface{}) {
 }
 
 // parseValue tries to parse a value from a string
-// according to the type hint. It doesn't do errors.
-func parseValue(s string, typ string) interface{} {
-	var v interface{}
+// according to the type hint. If the string can't be parsed, it
+// returns an error describing the problem.
+func parseValue(s string, typ string) (interface{}, error) {
 	switch typ {
 	case "int":
-		v, _ = strconv.Atoi(s)
-	case "string":
-		v = s
+		return strconv.Atoi(s)
+	case "int64":
+		return strconv.ParseInt(s, 10, 64)
+	case "uint":
+		u, err := strconv.ParseUint(s, 10, 0)
+		return uint(u), err
+	case "float":
+		return strconv.ParseFloat(s, 64)
+	case "string", "enum", "path":
+		return s, nil
 	case "[]string":
-		v = strings.Split(s, ",")
+		return strings.Split(s, ","), nil
 	case "bool":
 		// for flags, simply specifying the name means "true"
-		if s == "" || strings.HasPrefix(strings.ToLower(s), "t") {
-			v = true
-		} else {
-			v = false
+		switch strings.ToLower(s) {
+		case "", "t", "true", "y", "yes", "1", "on":
+			return true, nil
+		case "f", "false", "n", "no", "0", "off":
+			return false, nil
 		}
+		return nil, fmt.Errorf("expected true or false")
 	case "duration":
 		if s == "" {
-			return time.Duration(0)
-		}
-		d, err := time.ParseDuration(s)
-		if err != nil {
-			return time.Duration(0)
+			return time.Duration(0), nil
 		}
-		return d
+		return time.ParseDuration(s)
+	case "secret":
+		return NewSecret(s), nil
+	case "url":
+		return parseURL(s)
+	case "bytesize":
+		return ParseByteSize(s)
+	case "map":
+		return parseStringMap(s)
 	}
-	return v
+	return nil, fmt.Errorf("unknown config type %s", typ)
+}
+
+// setValue parses s and stores it as the value of the named item.
+func (cf *Config) setValue(name string, s string, source ConfigSource) error {
+	ag := (*cf)[name]
+	v, err := parseValue(s, ag.Type)
+	if err == nil {
+		err = checkItem(ag, v)
+	}
+	if err != nil {
+		return ParseError{Name: ag.Name, Value: s, Type: ag.Type, Err: err}
+	}
+	cf.store(name, v, s, source)
+	return nil
+}
+
+// store sets the value of the named item along with its provenance.
+func (cf *Config) store(name string, v interface{}, raw string, source ConfigSource) {
+	ag := (*cf)[name]
+	ag.Value = v
+	ag.Raw = raw
+	if ag.Secret {
+		ag.Raw = ""
+	}
+	ag.Source = source
+	(*cf)[name] = ag
+}
+
+// MarkSecret marks an existing config item as secret, so that its value
+// is redacted wherever the config is displayed.
+func (cf *Config) MarkSecret(name string) {
+	name = clean(name)
+	ag := (*cf)[name]
+	ag.Secret = true
+	ag.Raw = ""
+	(*cf)[name] = ag
 }
 
 // ParseCmdLine parses the command line and stores the values it finds
@@ -134,53 +235,133 @@ func parseValue(s string, typ string) interface{} {
 // case-insensitive. Hyphens and underscores (after the leading ones) are
 // equivalent. Values *must* be specified with an equals sign and be
 // part of the same argument, so `--foo=bar` is good, `--foo bar` is bad.
+// If there are any errors, it logs them and kills the server.
 func (cf *Config) ParseCmdLine() {
+	exitOnError(cf.ParseCmdLineE())
+}
+
+// ParseCmdLineE is like ParseCmdLine but returns a ConfigErrors listing
+// every unrecognized argument and unparseable value instead of exiting.
+func (cf *Config) ParseCmdLineE() error {
+	return cf.ParseArgsE(os.Args[1:])
+}
+
+// ParseArgsE parses args as if they were the command line (without the
+// program name) and stores the values it finds into the Config.
+func (cf *Config) ParseArgsE(args []string) error {
+	var errs ConfigErrors
 	argp := regexp.MustCompile(`^--?([A-Za-z0-9_-]+)(?:=(.+))?$`)
-	for ix := 1; ix < len(os.Args); ix++ {
-		if argp.MatchString(os.Args[ix]) {
-			m := argp.FindStringSubmatch(os.Args[ix])
+	for _, arg := range args {
+		if argp.MatchString(arg) {
+			m := argp.FindStringSubmatch(arg)
 			name := clean(m[1])
 			value := m[2]
-			ag, ok := (*cf)[name]
-			if ok {
-				ag.Value = parseValue(value, ag.Type)
-				(*cf)[name] = ag
+			if cf.isHelp(name) {
+				continue
+			}
+			if _, ok := (*cf)[name]; ok {
+				errs.add(cf.setValue(name, value, SourceFlag))
 			} else {
-				fmt.Println("unrecognized command line argument: ", os.Args[ix])
-				os.Exit(1)
+				errs.add(UnknownItemError{Source: "command line", Arg: arg})
 			}
 		}
 	}
+	return errs.errOrNil()
 }
 
 // ParseEnv reads the config and looks for environment variables that match,
-// parsing their values appropriately and overwriting existing configs
+// parsing their values appropriately and overwriting existing configs.
+// If there are any errors, it logs them and kills the server.
 func (cf *Config) ParseEnv() {
-	for name := range *cf {
-		ag := (*cf)[name]
+	exitOnError(cf.ParseEnvE())
+}
+
+// ParseEnvE is like ParseEnv but returns a ConfigErrors listing every
+// unparseable value instead of exiting.
+//
+// Secret items can also be read from a file named by NAME_FILE.
+func (cf *Config) ParseEnvE() error {
+	var errs ConfigErrors
+	for _, name := range cf.names() {
 		value := os.Getenv(clean(name))
 		if value != "" {
-			ag.Value = parseValue(value, ag.Type)
-			(*cf)[name] = ag
+			errs.add(cf.setValue(name, value, SourceEnv))
+			continue
+		}
+		if (*cf)[name].Type == "secret" {
+			value, found, err := readSecretFile(name)
+			if err != nil {
+				errs.add(fmt.Errorf("%s: %s", (*cf)[name].Name, err))
+			} else if found {
+				errs.add(cf.setValue(name, value, SourceEnv))
+			}
 		}
 	}
+	return errs.errOrNil()
 }
 
 // Check walks the config and looks for required config items that were
-// not specified; if it finds any, it logs it and kills the server.
+// not specified; if it finds any, it logs them and kills the server.
 func (cf *Config) Check() {
-	for name := range *cf {
+	exitOnError(cf.CheckE())
+}
+
+// CheckE is like Check but returns a ConfigErrors listing every missing
+// required item instead of exiting.
+func (cf *Config) CheckE() error {
+	var errs ConfigErrors
+	for _, name := range cf.names() {
 		ag := (*cf)[name]
 		if ag.Value == nil && ag.Default == nil {
-			fmt.Printf("required %s parameter %s was not found", ag.Type, ag.Name)
-			os.Exit(1)
+			errs.add(MissingError{Name: ag.Name, Type: ag.Type})
+			continue
+		}
+		// values that were set have been checked already, but defaults haven't
+		if def, ok := ag.Default.(string); ok && ag.Value == nil {
+			if err := checkDefault(ag, def); err != nil {
+				errs.add(ParseError{Name: ag.Name, Value: def, Type: ag.Type, Err: err})
+			}
 		}
 	}
+	return errs.errOrNil()
+}
+
+// checkDefault parses a default given as a string, as though it had been
+// set, so that a bad default is reported rather than read as a zero value.
+// An empty URL is allowed, and means there is none.
+func checkDefault(ag ConfigItem, def string) error {
+	if ag.Type == "url" && def == "" {
+		return nil
+	}
+	v, err := parseValue(def, ag.Type)
+	if err != nil {
+		return err
+	}
+	return checkItem(ag, v)
+}
+
+// names returns the keys of the config in sorted order, so that
+// anything that walks the config does so predictably.
+func (cf *Config) names() []string {
+	names := make([]string, 0, len(*cf))
+	for name := range *cf {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // Get is a generic Get that returns an interface and a flag if it was
 // found to be a valid config variable.
+// It is safe to call while the config is being reloaded.
 func (cf *Config) Get(name string) (interface{}, bool) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return cf.get(name)
+}
+
+// get is Get without the locking.
+func (cf *Config) get(name string) (interface{}, bool) {
 	ag, ok := (*cf)[clean(name)]
 	if !ok {
 		return nil, false
@@ -244,14 +425,49 @@ func (cf *Config) GetStringArray(name string) []string {
 	return v.([]string)
 }
 
-// Load fetches config values from the environment, and then from the command
+// Load fetches config values from the config file (if there is a "config"
+// item naming one), then from the environment, and then from the command
 // line, then checks to see if any required variables were missing.
-// Envvars are higher priority than default values, and cmd line is higher
-// priority than envvars.
+// File values are higher priority than default values, envvars are higher
+// priority than file values, and cmd line is higher priority than envvars.
+// If there are any errors, it logs all of them and kills the server.
+// If --help was given, it prints the usage and exits.
 func (cf *Config) Load() {
-	cf.ParseEnv()
-	cf.ParseCmdLine()
-	cf.Check()
+	err := cf.LoadE()
+	if err == ErrHelp {
+		cf.Usage(os.Stdout, filepath.Base(os.Args[0]))
+		os.Exit(0)
+	}
+	exitOnError(err)
+}
+
+// LoadE is like Load but returns a ConfigErrors listing every problem
+// it found instead of exiting. If --help was given, it returns ErrHelp
+// after loading, so that Usage can show the effective values.
+func (cf *Config) LoadE() error {
+	errs := cf.load()
+	cf.fill()
+	if cf.helpRequested(os.Args[1:]) {
+		return ErrHelp
+	}
+	return errs.errOrNil()
+}
+
+// load reads all of the sources in priority order and checks the result.
+func (cf *Config) load() ConfigErrors {
+	var errs ConfigErrors
+	errs.add(cf.ParseEnvE())
+	errs.add(cf.ParseCmdLineE())
+	// The file is the lowest-priority source, but its name can come from
+	// the env or cmd line, so we read it and then reapply those on top.
+	// Their errors have already been collected.
+	if path := cf.GetString("config"); path != "" {
+		errs.add(cf.ParseFileE(path))
+		cf.ParseEnvE()
+		cf.ParseCmdLineE()
+	}
+	errs.add(cf.CheckE())
+	return errs
 }
 
 // NewConfig constructs an empty config
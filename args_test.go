@@ -0,0 +1,199 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testConfig() *Config {
+	cf := NewConfig()
+	cf.AddInt("port", 8080)
+	cf.AddDuration("timeout", "1s")
+	cf.AddFlag("verbose", false)
+	cf.AddRequiredString("name")
+	cf.AddString("config", "")
+	return cf
+}
+
+// withArgs runs f with os.Args set to the program name followed by args.
+func withArgs(args []string, f func()) {
+	saved := os.Args
+	defer func() { os.Args = saved }()
+	os.Args = append([]string{"test"}, args...)
+	f()
+}
+
+func TestParseArgsE(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []error
+	}{
+		{"good", []string{"--port=9000", "-name=svc", "--VERBOSE=true"}, nil},
+		{"unknown", []string{"--bogus=1"}, []error{UnknownItemError{}}},
+		{"bad int", []string{"--port=lots"}, []error{ParseError{}}},
+		{"bad duration", []string{"--timeout=soon"}, []error{ParseError{}}},
+		{"all of them", []string{"--bogus=1", "--port=lots", "--timeout=soon", "--name=ok"},
+			[]error{UnknownItemError{}, ParseError{}, ParseError{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := testConfig().ParseArgsE(tt.args)
+			checkConfigErrors(t, err, tt.want)
+		})
+	}
+}
+
+func TestParseArgsEValues(t *testing.T) {
+	cf := testConfig()
+	if err := cf.ParseArgsE([]string{"--port=9000", "--name=svc"}); err != nil {
+		t.Fatal(err)
+	}
+	if cf.GetInt("port") != 9000 || cf.GetString("name") != "svc" {
+		t.Errorf("got port %d, name %q", cf.GetInt("port"), cf.GetString("name"))
+	}
+	if (*cf)["PORT"].Source != SourceFlag {
+		t.Errorf("port source is %q", (*cf)["PORT"].Source)
+	}
+}
+
+func TestCheckE(t *testing.T) {
+	cf := testConfig()
+	err := cf.CheckE()
+	checkConfigErrors(t, err, []error{MissingError{}})
+	var missing MissingError
+	if !errors.As(err.(ConfigErrors)[0], &missing) || missing.Name != "name" {
+		t.Errorf("got %v", err)
+	}
+
+	cf.AddEnum("level", "loud", "quiet", "normal")
+	// items are checked in order of their names
+	checkConfigErrors(t, cf.CheckE(), []error{ParseError{}, MissingError{}})
+
+	if err := cf.ParseArgsE([]string{"--name=svc", "--level=quiet"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.CheckE(); err != nil {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCheckEDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		add  func(cf *Config)
+		ok   bool
+	}{
+		{"url", func(cf *Config) { cf.AddURL("u", "http://localhost:8080/") }, true},
+		{"empty url", func(cf *Config) { cf.AddURL("u", "") }, true},
+		{"relative url", func(cf *Config) { cf.AddURL("u", "/path") }, false},
+		{"bytesize", func(cf *Config) { cf.AddByteSize("b", "10MiB") }, true},
+		{"bad bytesize", func(cf *Config) { cf.AddByteSize("b", "lots") }, false},
+		{"duration", func(cf *Config) { cf.AddDuration("d", "1m") }, true},
+		{"bad duration", func(cf *Config) { cf.AddDuration("d", "soon") }, false},
+		{"map", func(cf *Config) { cf.AddStringMap("m", "a=1,b=2") }, true},
+		{"bad map", func(cf *Config) { cf.AddStringMap("m", "a") }, false},
+		{"enum", func(cf *Config) { cf.AddEnum("e", "x", "x", "y") }, true},
+		{"bad enum", func(cf *Config) { cf.AddEnum("e", "z", "x", "y") }, false},
+		{"string default for an int", func(cf *Config) { cf.AddInt("i", 0); cf.SetDefault("i", "ten") }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf := NewConfig()
+			tt.add(cf)
+			var want []error
+			if !tt.ok {
+				want = []error{ParseError{}}
+			}
+			checkConfigErrors(t, cf.CheckE(), want)
+		})
+	}
+
+	// a value that was set replaces a bad default
+	cf := NewConfig()
+	cf.AddByteSize("b", "lots")
+	if err := cf.ParseArgsE([]string{"--b=1KB"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.CheckE(); err != nil {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestLoadE(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "svc.yaml")
+	if err := ioutil.WriteFile(path, []byte("port: many\nextra: 1\nname: fromfile\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TIMEOUT", "whenever")
+	cf := testConfig()
+	var err error
+	withArgs([]string{"--config=" + path, "--bogus=1"}, func() { err = cf.LoadE() })
+	// the env and command line are read twice, but their errors are
+	// reported once
+	checkConfigErrors(t, err, []error{ParseError{}, UnknownItemError{}, UnknownItemError{}, ParseError{}})
+	if cf.GetString("name") != "fromfile" {
+		t.Errorf("name = %q", cf.GetString("name"))
+	}
+
+	t.Setenv("TIMEOUT", "")
+	cf = testConfig()
+	withArgs(nil, func() { err = cf.LoadE() })
+	checkConfigErrors(t, err, []error{MissingError{}})
+
+	cf = testConfig()
+	withArgs([]string{"--name=svc"}, func() { err = cf.LoadE() })
+	if err != nil {
+		t.Errorf("got %v", err)
+	}
+}
+
+// checkConfigErrors checks that err is a ConfigErrors whose elements have
+// the same types as want, in order, or nil if want is empty.
+func checkConfigErrors(t *testing.T, err error, want []error) {
+	t.Helper()
+	if len(want) == 0 {
+		if err != nil {
+			t.Errorf("got %v, want no error", err)
+		}
+		return
+	}
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("got %T %v, want ConfigErrors", err, err)
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(errs), len(want), err)
+	}
+	for i := range want {
+		if got, exp := typeName(errs[i]), typeName(want[i]); got != exp {
+			t.Errorf("error %d is a %s, want a %s: %v", i, got, exp, errs[i])
+		}
+	}
+}
+
+func typeName(err error) string {
+	switch err.(type) {
+	case UnknownItemError:
+		return "UnknownItemError"
+	case ParseError:
+		return "ParseError"
+	case MissingError:
+		return "MissingError"
+	}
+	return "other"
+}
@@ -0,0 +1,324 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request doesn't
+// carry the kind of credentials it handles, so that the next one can try.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is the authenticated identity behind a request.
+type Principal struct {
+	// ID is the API key's name, the JWT's subject, the HMAC key ID, or the
+	// client certificate's common name.
+	ID string
+	// Method is the kind of authentication used: "apikey", "jwt", "hmac",
+	// or "mtls".
+	Method string
+	// Claims holds the JWT claims, if any.
+	Claims map[string]interface{}
+}
+
+// Authenticator checks the credentials in a request. It returns
+// ErrNoCredentials if the request has none of the kind it handles, or
+// another error if they are present but invalid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// MultiAuth tries each of its Authenticators in turn, and uses the first
+// one that finds credentials in the request.
+type MultiAuth []Authenticator
+
+// Authenticate implements Authenticator.
+func (m MultiAuth) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range m {
+		p, err := a.Authenticate(r)
+		if err != ErrNoCredentials {
+			return p, err
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+// AuthPolicy says whether a route requires authentication.
+type AuthPolicy string
+
+// These are the possible auth policies. With AuthOptional, requests without
+// credentials are allowed through anonymously, but invalid credentials are
+// still rejected.
+const (
+	AuthNone     AuthPolicy = "none"
+	AuthOptional AuthPolicy = "optional"
+	AuthRequired AuthPolicy = "required"
+)
+
+// AuthPolicyProvider is an optional interface that a Builder can implement
+// to set the auth policy of its routes, keyed by route pattern (like
+// /count/:first/:last). The AUTH_ROUTES config item overrides it.
+// StandardSetup refuses to start if a pattern isn't one of its routes.
+type AuthPolicyProvider interface {
+	AuthPolicies() map[string]AuthPolicy
+}
+
+// AuthPolicies holds the default auth policy and per-route exceptions.
+type AuthPolicies struct {
+	Default AuthPolicy
+	Routes  map[string]AuthPolicy
+}
+
+// For returns the policy for the route the request matched (see RouteMW).
+func (ap AuthPolicies) For(r *http.Request) AuthPolicy {
+	if p, ok := ap.Routes[RoutePattern(r)]; ok {
+		return p
+	}
+	if ap.Default == "" {
+		return AuthNone
+	}
+	return ap.Default
+}
+
+// checkRoutes returns an error naming the routes with a policy that aren't
+// in rt, since a misspelled pattern would otherwise leave the route it
+// meant with the default policy.
+func (ap AuthPolicies) checkRoutes(rt *RouteTable) error {
+	var unknown []string
+	for route := range ap.Routes {
+		if !rt.Has(route) {
+			unknown = append(unknown, route)
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("auth policies for unknown routes: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the principal stored in ctx, or nil if
+// the request wasn't authenticated.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// GetPrincipal returns the principal that authenticated the request, or nil.
+func GetPrincipal(r *http.Request) *Principal {
+	return PrincipalFromContext(r.Context())
+}
+
+// AuthMW wraps a handler and authenticates requests according to the
+// policy for their route, storing the principal in the request context.
+// Requests that fail are rejected with a 401 JSON error.
+// It must be installed inside RouteMW for per-route policies to work.
+func AuthMW(auth Authenticator, policies AuthPolicies, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		policy := policies.For(r)
+		if policy == AuthNone {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		p, err := auth.Authenticate(r)
+		switch {
+		case err == ErrNoCredentials && policy == AuthOptional:
+			handler.ServeHTTP(w, r)
+			return
+		case err == ErrNoCredentials:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			WriteJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		case err != nil:
+			Logger(r).WithError(err).Info("authentication failed")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			WriteJSONError(w, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+		ctx := context.WithValue(r.Context(), principalKey{}, p)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// APIKeyHeader is the header that carries static API keys.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyAuth authenticates requests with static API keys in the X-API-Key
+// header. It maps each key to the name of its principal.
+type APIKeyAuth struct {
+	keys map[string]string
+}
+
+// NewAPIKeyAuth creates an APIKeyAuth from a map of principal names to keys.
+func NewAPIKeyAuth(keys map[string]string) *APIKeyAuth {
+	a := &APIKeyAuth{keys: make(map[string]string, len(keys))}
+	for name, key := range keys {
+		a.keys[key] = name
+	}
+	return a
+}
+
+// Authenticate implements Authenticator.
+func (a *APIKeyAuth) Authenticate(r *http.Request) (*Principal, error) {
+	given := r.Header.Get(APIKeyHeader)
+	if given == "" {
+		return nil, ErrNoCredentials
+	}
+	// compare against every key so that timing doesn't reveal which
+	// prefixes are valid
+	var found string
+	for key, name := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(given)) == 1 {
+			found = name
+		}
+	}
+	if found == "" {
+		return nil, errors.New("unknown API key")
+	}
+	return &Principal{ID: found, Method: "apikey"}, nil
+}
+
+// parseKeyLines parses lines of the form "name:key", ignoring blank lines
+// and lines starting with #. Names can't contain colons, but keys can.
+func parseKeyLines(lines []string, source string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("%s line %d: expected name:key", source, i+1)
+		}
+		keys[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return keys, nil
+}
+
+// readKeyFile reads a file of "name:key" lines.
+func readKeyFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return parseKeyLines(lines, path)
+}
+
+// addAuthConfig adds the config items used by AuthFromConfig.
+func addAuthConfig(cf *Config) {
+	cf.AddEnum("AUTH", string(AuthNone), string(AuthNone), string(AuthOptional), string(AuthRequired))
+	cf.Describe("AUTH", "default auth policy for routes")
+	cf.AddStringMap("AUTH_ROUTES", "", "per-route auth policies, like /count/:first/:last=required")
+	cf.AddPath("AUTH_API_KEYS_FILE", "", true, "file of name:key lines for API key auth")
+	cf.AddSecret("AUTH_API_KEYS", "comma-separated name:key pairs for API key auth")
+	cf.AddPath("AUTH_JWKS_FILE", "", true, "JWKS file of keys for JWT bearer auth")
+	cf.AddString("AUTH_JWT_ISSUER", "", "required JWT issuer (iss), if any")
+	cf.AddString("AUTH_JWT_AUDIENCE", "", "required JWT audience (aud), if any")
+	cf.AddPath("AUTH_HMAC_KEYS_FILE", "", true, "file of id:secret lines for HMAC request signatures")
+	cf.AddDuration("AUTH_HMAC_WINDOW", "5m", "how far a signed request's timestamp may be from now")
+}
+
+// AuthFromConfig creates the authenticators enabled by the config (API
+// keys, JWT, HMAC, and client certificates, tried in that order) and the
+// auth policies.
+// Policies from the builders that are AuthPolicyProviders are overridden
+// by AUTH_ROUTES.
+func AuthFromConfig(cf *Config, builders ...Builder) (MultiAuth, AuthPolicies, error) {
+	var auth MultiAuth
+
+	apikeys := make(map[string]string)
+	if path := cf.GetPath("AUTH_API_KEYS_FILE"); path != "" {
+		keys, err := readKeyFile(path)
+		if err != nil {
+			return nil, AuthPolicies{}, err
+		}
+		for name, key := range keys {
+			apikeys[name] = key
+		}
+	}
+	if s := cf.GetSecret("AUTH_API_KEYS"); !s.IsEmpty() {
+		keys, err := parseKeyLines(strings.Split(s.Reveal(), ","), "AUTH_API_KEYS")
+		if err != nil {
+			return nil, AuthPolicies{}, err
+		}
+		for name, key := range keys {
+			apikeys[name] = key
+		}
+	}
+	if len(apikeys) != 0 {
+		auth = append(auth, NewAPIKeyAuth(apikeys))
+	}
+
+	if path := cf.GetPath("AUTH_JWKS_FILE"); path != "" {
+		ja, err := NewJWTAuthFromFile(path, cf.GetString("AUTH_JWT_ISSUER"), cf.GetString("AUTH_JWT_AUDIENCE"))
+		if err != nil {
+			return nil, AuthPolicies{}, err
+		}
+		auth = append(auth, ja)
+	}
+
+	if path := cf.GetPath("AUTH_HMAC_KEYS_FILE"); path != "" {
+		keys, err := readKeyFile(path)
+		if err != nil {
+			return nil, AuthPolicies{}, err
+		}
+		auth = append(auth, NewHMACAuth(keys, cf.GetDuration("AUTH_HMAC_WINDOW")))
+	}
+
+	if ca := cf.GetEnum("TLS_CLIENT_AUTH"); ca == "optional" || ca == "required" {
+		auth = append(auth, ClientCertAuth{})
+	}
+
+	policies := AuthPolicies{
+		Default: AuthPolicy(cf.GetEnum("AUTH")),
+		Routes:  make(map[string]AuthPolicy),
+	}
+	for _, builder := range builders {
+		if app, ok := builder.(AuthPolicyProvider); ok {
+			for route, p := range app.AuthPolicies() {
+				policies.Routes[route] = p
+			}
+		}
+	}
+	for route, p := range cf.GetStringMap("AUTH_ROUTES") {
+		switch AuthPolicy(p) {
+		case AuthNone, AuthOptional, AuthRequired:
+			policies.Routes[route] = AuthPolicy(p)
+		default:
+			return nil, AuthPolicies{}, fmt.Errorf("AUTH_ROUTES: unknown policy %q for %s", p, route)
+		}
+	}
+	if policies.Default != AuthNone && len(auth) == 0 {
+		return nil, AuthPolicies{}, errors.New("AUTH is enabled but no API keys, JWKS file, HMAC keys, or client CAs are configured")
+	}
+	return auth, policies, nil
+}
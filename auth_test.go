@@ -0,0 +1,353 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	a := NewAPIKeyAuth(map[string]string{"alice": "k-alice", "bob": "k-bob"})
+	tests := []struct {
+		name   string
+		header string
+		want   string
+		err    bool
+	}{
+		{"valid", "k-bob", "bob", false},
+		{"unknown", "k-mallory", "", true},
+		{"prefix", "k-ali", "", true},
+		{"missing", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set(APIKeyHeader, tt.header)
+			}
+			p, err := a.Authenticate(r)
+			switch {
+			case tt.header == "":
+				if err != ErrNoCredentials {
+					t.Errorf("got %v, want ErrNoCredentials", err)
+				}
+			case tt.err:
+				if err == nil || err == ErrNoCredentials {
+					t.Errorf("got %+v, %v", p, err)
+				}
+			case err != nil || p.ID != tt.want || p.Method != "apikey":
+				t.Errorf("got %+v, %v", p, err)
+			}
+		})
+	}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// jwtKeys are a key of each supported type, with a JWKS that has their
+// public halves.
+type jwtKeys struct {
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+	secret []byte
+	jwks   JWKS
+}
+
+func newJWTKeys(t *testing.T) *jwtKeys {
+	t.Helper()
+	k := &jwtKeys{secret: []byte("sekrit")}
+	var err error
+	if k.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if k.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	k.jwks = JWKS{Keys: []JWK{
+		{Kty: "RSA", Kid: "rsa", Alg: "RS256", N: b64(k.rsa.N.Bytes()), E: b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{Kty: "EC", Kid: "ec", Crv: "P-256", X: b64(k.ec.X.Bytes()), Y: b64(k.ec.Y.Bytes())},
+		{Kty: "oct", Kid: "oct", K: b64(k.secret)},
+	}}
+	return k
+}
+
+// sign makes a token with the given method, kid and claims.
+func sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJWTAuth(t *testing.T) {
+	k := newJWTKeys(t)
+	ja, err := NewJWTAuth(k.jwks, "issuer", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := x509.MarshalPKIXPublicKey(&k.rsa.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub": "alice",
+			"iss": "issuer",
+			"aud": "api",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"RS256", sign(t, jwt.SigningMethodRS256, "rsa", claims(nil), k.rsa), true},
+		{"ES256", sign(t, jwt.SigningMethodES256, "ec", claims(nil), k.ec), true},
+		{"HS256", sign(t, jwt.SigningMethodHS256, "oct", claims(nil), k.secret), true},
+		{"RS512", sign(t, jwt.SigningMethodRS512, "rsa", claims(nil), k.rsa), false},
+		{"none", sign(t, jwt.SigningMethodNone, "rsa", claims(nil), jwt.UnsafeAllowNoneSignatureType), false},
+		{"HS256 with the RSA key", sign(t, jwt.SigningMethodHS256, "rsa", claims(nil), rsaPub), false},
+		{"ES256 with the RSA kid", sign(t, jwt.SigningMethodES256, "rsa", claims(nil), k.ec), false},
+		{"unknown kid", sign(t, jwt.SigningMethodHS256, "other", claims(nil), k.secret), false},
+		{"wrong secret", sign(t, jwt.SigningMethodHS256, "oct", claims(nil), []byte("guess")), false},
+		{"wrong issuer", sign(t, jwt.SigningMethodRS256, "rsa", claims(func(c jwt.MapClaims) { c["iss"] = "someone" }), k.rsa), false},
+		{"no issuer", sign(t, jwt.SigningMethodRS256, "rsa", claims(func(c jwt.MapClaims) { delete(c, "iss") }), k.rsa), false},
+		{"wrong audience", sign(t, jwt.SigningMethodRS256, "rsa", claims(func(c jwt.MapClaims) { c["aud"] = "web" }), k.rsa), false},
+		{"audience list", sign(t, jwt.SigningMethodRS256, "rsa", claims(func(c jwt.MapClaims) { c["aud"] = []string{"web", "api"} }), k.rsa), true},
+		{"expired", sign(t, jwt.SigningMethodRS256, "rsa", claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }), k.rsa), false},
+		{"not yet valid", sign(t, jwt.SigningMethodRS256, "rsa", claims(func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(time.Hour).Unix() }), k.rsa), false},
+		{"no subject", sign(t, jwt.SigningMethodRS256, "rsa", claims(func(c jwt.MapClaims) { delete(c, "sub") }), k.rsa), false},
+		{"garbage", "not.a.token", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			p, err := ja.Authenticate(r)
+			if tt.ok {
+				if err != nil || p.ID != "alice" || p.Method != "jwt" {
+					t.Errorf("got %+v, %v", p, err)
+				}
+			} else if err == nil || err == ErrNoCredentials {
+				t.Errorf("accepted: %+v, %v", p, err)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Basic YWxpY2U6cGFzcw==")
+	if _, err := ja.Authenticate(r); err != ErrNoCredentials {
+		t.Errorf("basic auth got %v", err)
+	}
+}
+
+func TestJWKAlgorithm(t *testing.T) {
+	k := newJWTKeys(t)
+	tests := []struct {
+		name string
+		jwk  JWK
+	}{
+		{"alg for another key type", JWK{Kty: "oct", Kid: "oct", Alg: "RS256", K: b64(k.secret)}},
+		{"unsupported alg", JWK{Kty: "oct", Kid: "oct", Alg: "HS512", K: b64(k.secret)}},
+		{"unsupported curve", JWK{Kty: "EC", Kid: "ec", Crv: "P-384", X: b64(k.ec.X.Bytes()), Y: b64(k.ec.Y.Bytes())}},
+		{"point off the curve", JWK{Kty: "EC", Kid: "ec", Crv: "P-256", X: b64(k.ec.X.Bytes()), Y: b64([]byte{1})}},
+	}
+	for _, tt := range tests {
+		if _, err := NewJWTAuth(JWKS{Keys: []JWK{tt.jwk}}, "", ""); err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
+
+// signed returns a request signed with the given key and time.
+func signed(t *testing.T, id, secret, body string, at time.Time) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/count/1/10?x=1", strings.NewReader(body))
+	if err := SignRequest(r, id, secret, at); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestHMACAuth(t *testing.T) {
+	ha := NewHMACAuth(map[string]string{"svc": "sekrit"}, time.Minute)
+	now := time.Now()
+	tests := []struct {
+		name string
+		r    func() *http.Request
+		ok   bool
+	}{
+		{"valid", func() *http.Request { return signed(t, "svc", "sekrit", "a", now) }, true},
+		{"small skew", func() *http.Request { return signed(t, "svc", "sekrit", "b", now.Add(-30*time.Second)) }, true},
+		{"future skew", func() *http.Request { return signed(t, "svc", "sekrit", "c", now.Add(30*time.Second)) }, true},
+		{"too old", func() *http.Request { return signed(t, "svc", "sekrit", "d", now.Add(-2*time.Minute)) }, false},
+		{"too new", func() *http.Request { return signed(t, "svc", "sekrit", "e", now.Add(2*time.Minute)) }, false},
+		{"unknown key", func() *http.Request { return signed(t, "other", "sekrit", "f", now) }, false},
+		{"wrong secret", func() *http.Request { return signed(t, "svc", "guess", "g", now) }, false},
+		{"changed body", func() *http.Request {
+			r := signed(t, "svc", "sekrit", "h", now)
+			r.Body = httptest.NewRequest("POST", "/", strings.NewReader("H")).Body
+			return r
+		}, false},
+		{"changed query", func() *http.Request {
+			r := signed(t, "svc", "sekrit", "i", now)
+			r.URL.RawQuery = "x=2"
+			return r
+		}, false},
+		{"replay", func() *http.Request { return signed(t, "svc", "sekrit", "a", now) }, false},
+		{"replay in upper case", func() *http.Request {
+			r := signed(t, "svc", "sekrit", "a", now)
+			r.Header.Set(HMACSignatureHeader, strings.ToUpper(r.Header.Get(HMACSignatureHeader)))
+			return r
+		}, false},
+		{"upper case", func() *http.Request {
+			r := signed(t, "svc", "sekrit", "j", now)
+			r.Header.Set(HMACSignatureHeader, strings.ToUpper(r.Header.Get(HMACSignatureHeader)))
+			return r
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ha.Authenticate(tt.r())
+			if tt.ok {
+				if err != nil || p.ID != "svc" || p.Method != "hmac" {
+					t.Errorf("got %+v, %v", p, err)
+				}
+			} else if err == nil || err == ErrNoCredentials {
+				t.Errorf("accepted: %+v, %v", p, err)
+			}
+		})
+	}
+
+	if _, err := ha.Authenticate(httptest.NewRequest("GET", "/", nil)); err != ErrNoCredentials {
+		t.Errorf("unsigned request got %v", err)
+	}
+}
+
+func TestHMACReplayCacheExpires(t *testing.T) {
+	ha := NewHMACAuth(nil, time.Minute)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		ha.remember(string(rune('a'+i)), start.Add(time.Duration(i)*time.Second))
+	}
+	if !ha.remember("z", start.Add(2*time.Minute+5500*time.Millisecond)) {
+		t.Fatal("z was already seen")
+	}
+	// a to f have expired
+	if len(ha.seen) != 5 || len(ha.order) != 5 {
+		t.Errorf("%d seen, %d in order", len(ha.seen), len(ha.order))
+	}
+	if ha.remember("g", start.Add(2*time.Minute+5500*time.Millisecond)) {
+		t.Error("g expired early")
+	}
+}
+
+func TestAuthMW(t *testing.T) {
+	auth := MultiAuth{NewAPIKeyAuth(map[string]string{"alice": "k-alice"})}
+	tests := []struct {
+		policy AuthPolicy
+		key    string
+		status int
+		who    string
+	}{
+		{AuthNone, "", http.StatusOK, ""},
+		{AuthNone, "k-alice", http.StatusOK, ""},
+		{AuthNone, "bad", http.StatusOK, ""},
+		{AuthOptional, "", http.StatusOK, ""},
+		{AuthOptional, "k-alice", http.StatusOK, "alice"},
+		{AuthOptional, "bad", http.StatusUnauthorized, ""},
+		{AuthRequired, "", http.StatusUnauthorized, ""},
+		{AuthRequired, "k-alice", http.StatusOK, "alice"},
+		{AuthRequired, "bad", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy)+" "+tt.key, func(t *testing.T) {
+			rt := NewRouteTable(nil)
+			rt.Add(AnyMethod, "/open")
+			rt.Add(AnyMethod, "/private")
+			policies := AuthPolicies{Default: AuthNone, Routes: map[string]AuthPolicy{"/private": tt.policy}}
+			var who string
+			handler := RequestIDMW(quietLogger(), RouteMW(rt, AuthMW(auth, policies, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if p := GetPrincipal(r); p != nil {
+					who = p.ID
+				}
+			}))))
+			r := httptest.NewRequest("GET", "/private", nil)
+			if tt.key != "" {
+				r.Header.Set(APIKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status || who != tt.who {
+				t.Errorf("got %d for %q", w.Code, who)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+
+			// the default applies to other routes
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/open", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("default route got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestAuthPolicyRoutes(t *testing.T) {
+	rt := NewRouteTable(nil)
+	rt.Add("GET", "/count/:first/:last")
+	rt.Add(AnyMethod, "/health/ready")
+	good := AuthPolicies{Routes: map[string]AuthPolicy{
+		"/count/:first/:last": AuthRequired,
+		"/health/ready":       AuthNone,
+	}}
+	if err := good.checkRoutes(rt); err != nil {
+		t.Error(err)
+	}
+	bad := AuthPolicies{Routes: map[string]AuthPolicy{
+		"/count/:first/:last": AuthRequired,
+		"/count/:from/:to":    AuthRequired,
+		"/health/ready/":      AuthNone,
+		"/count":              AuthRequired,
+	}}
+	err := bad.checkRoutes(rt)
+	if err == nil || err.Error() != "auth policies for unknown routes: /count, /count/:from/:to, /health/ready/" {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// bindTypes maps the Go types that can be bound to the config type used
+// for them. Named types (like `type Port int`) are matched by their kind
+// if they aren't listed here.
+var bindTypes = map[reflect.Type]string{
+	reflect.TypeOf(int(0)):              "int",
+	reflect.TypeOf(""):                  "string",
+	reflect.TypeOf([]string{}):          "[]string",
+	reflect.TypeOf(false):               "bool",
+	reflect.TypeOf(time.Duration(0)):    "duration",
+	reflect.TypeOf(Secret{}):            "secret",
+	reflect.TypeOf(int64(0)):            "int64",
+	reflect.TypeOf(uint(0)):             "uint",
+	reflect.TypeOf(float64(0)):          "float",
+	reflect.TypeOf(&url.URL{}):          "url",
+	reflect.TypeOf(ByteSize(0)):         "bytesize",
+	reflect.TypeOf(map[string]string{}): "map",
+}
+
+// bindType returns the config type for a Go type, or "" if it can't be bound.
+func bindType(t reflect.Type) string {
+	if typ, ok := bindTypes[t]; ok {
+		return typ
+	}
+	switch t.Kind() {
+	case reflect.Int:
+		return "int"
+	case reflect.Int64:
+		return "int64"
+	case reflect.Uint:
+		return "uint"
+	case reflect.Float64:
+		return "float"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return "[]string"
+		}
+	case reflect.Map:
+		if t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.String {
+			return "map"
+		}
+	}
+	return ""
+}
+
+// bindTag is the parsed form of a `rest:"..."` struct tag.
+type bindTag struct {
+	name     string
+	def      string
+	hasDef   bool
+	required bool
+	help     string
+	allowed  []string
+	path     bool
+	exist    bool
+}
+
+// parseBindTag parses a tag like `port,default=8080,required,help=...`.
+// String fields can also be tagged `enum=a|b|c` to make them enums, or
+// `path` (or `mustexist`) to make them paths.
+// Since help text is free-form, help= must come last and takes the rest
+// of the tag, commas and all. A default that contains commas, like a list
+// or map, is quoted with single quotes: `hosts,default='a,b'`.
+func parseBindTag(tag string) bindTag {
+	var bt bindTag
+	parts := splitBindTag(tag)
+	bt.name = strings.TrimSpace(parts[0])
+	for i := 1; i < len(parts); i++ {
+		part := strings.TrimSpace(parts[i])
+		switch {
+		case part == "required":
+			bt.required = true
+		case part == "path":
+			bt.path = true
+		case part == "mustexist":
+			bt.path = true
+			bt.exist = true
+		case strings.HasPrefix(part, "enum="):
+			bt.allowed = strings.Split(strings.TrimPrefix(part, "enum="), "|")
+		case strings.HasPrefix(part, "default="):
+			bt.def = strings.TrimPrefix(part, "default=")
+			if len(bt.def) >= 2 && bt.def[0] == '\'' && bt.def[len(bt.def)-1] == '\'' {
+				bt.def = bt.def[1 : len(bt.def)-1]
+			}
+			bt.hasDef = true
+		case strings.HasPrefix(part, "help="):
+			bt.help = strings.TrimPrefix(strings.Join(parts[i:], ","), "help=")
+			return bt
+		}
+	}
+	return bt
+}
+
+// splitBindTag splits a tag at the commas that aren't inside single quotes.
+func splitBindTag(tag string) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i, c := range tag {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, tag[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, tag[start:])
+}
+
+// Bind registers a config item for every exported field of the struct that
+// p points to, and arranges for the fields to be filled in with their typed
+// values when the config is loaded. The fields aren't changed by Reload,
+// so that they can be read without locking. Fields are configured with
+// tags like
+//
+//	Port int `rest:"port,default=8080,required,help=port to listen on"`
+//
+// The name defaults to the field name, and a name of "-" skips the field.
+// If there is no default in the tag, the field's current value is used,
+// unless the field is required. Fields that are themselves structs are
+// bound recursively, with their names prefixed by the struct field's name
+// and an underscore.
+//
+// If an item with the same name already exists (for example, one from
+// DefaultConfig), the field is bound to it; its type must agree.
+func (cf *Config) Bind(p interface{}) error {
+	v := reflect.ValueOf(p)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Bind requires a pointer to a struct, not %T", p)
+	}
+	var errs ConfigErrors
+	errs.add(cf.bindStruct(v.Elem(), ""))
+	return errs.errOrNil()
+}
+
+// hasExportedFields reports whether a struct type can be bound field by
+// field. Opaque structs like time.Time have nothing to bind, and are
+// rejected rather than silently skipped.
+func hasExportedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (cf *Config) bindStruct(v reflect.Value, prefix string) error {
+	var errs ConfigErrors
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			// unexported
+			continue
+		}
+		tag := parseBindTag(sf.Tag.Get("rest"))
+		if tag.name == "-" {
+			continue
+		}
+		if tag.name == "" {
+			tag.name = sf.Name
+		}
+		if prefix != "" {
+			tag.name = prefix + "_" + tag.name
+		}
+		fv := v.Field(i)
+		typ := bindType(sf.Type)
+		if typ == "" && sf.Type.Kind() == reflect.Struct && hasExportedFields(sf.Type) {
+			errs.add(cf.bindStruct(fv, tag.name))
+			continue
+		}
+		if typ == "" {
+			errs.add(fmt.Errorf("%s: cannot bind field %s of type %s", tag.name, sf.Name, sf.Type))
+			continue
+		}
+		if typ == "string" && tag.allowed != nil {
+			typ = "enum"
+		} else if typ == "string" && tag.path {
+			typ = "path"
+		}
+		errs.add(cf.bindField(fv, typ, tag))
+	}
+	return errs.errOrNil()
+}
+
+func (cf *Config) bindField(fv reflect.Value, typ string, tag bindTag) error {
+	name := clean(tag.name)
+	ag, exists := (*cf)[name]
+	// a plain string field can hold the value of an enum or path item
+	if exists && typ == "string" && (ag.Type == "enum" || ag.Type == "path") {
+		typ = ag.Type
+	}
+	if exists && ag.Type != typ {
+		return fmt.Errorf("%s: cannot bind %s field to existing %s item", tag.name, typ, ag.Type)
+	}
+	if !exists {
+		ag = ConfigItem{Name: tag.name, Type: typ, Allowed: tag.allowed, MustExist: tag.exist}
+		if !tag.required {
+			ag.Default = typedField(fv, typ)
+		}
+	}
+	if tag.hasDef {
+		def, err := parseValue(tag.def, typ)
+		if err == nil {
+			err = checkItem(ag, def)
+		}
+		if err != nil {
+			return ParseError{Name: tag.name, Value: tag.def, Type: typ, Err: err}
+		}
+		ag.Default = def
+	}
+	if tag.required {
+		ag.Default = nil
+	}
+	if tag.help != "" {
+		ag.Help = tag.help
+	}
+	ag.targets = append(ag.targets, fv)
+	(*cf)[name] = ag
+	return nil
+}
+
+// typedField returns the value of a bound field as the type that the
+// config uses internally, so that it can serve as a default.
+func typedField(fv reflect.Value, typ string) interface{} {
+	switch typ {
+	case "int":
+		return int(fv.Int())
+	case "int64":
+		return fv.Int()
+	case "uint":
+		return uint(fv.Uint())
+	case "float":
+		return fv.Float()
+	case "string", "enum", "path":
+		return fv.String()
+	case "url":
+		// a nil URL means "no default", not "required"
+		if fv.IsNil() {
+			return ""
+		}
+		return fv.Interface().(*url.URL)
+	case "bytesize":
+		return ByteSize(fv.Int())
+	case "map":
+		m := make(map[string]string, fv.Len())
+		for _, k := range fv.MapKeys() {
+			m[k.String()] = fv.MapIndex(k).String()
+		}
+		return m
+	case "[]string":
+		s := make([]string, fv.Len())
+		for i := range s {
+			s[i] = fv.Index(i).String()
+		}
+		return s
+	case "bool":
+		return fv.Bool()
+	case "duration":
+		return time.Duration(fv.Int())
+	case "secret":
+		return fv.Interface().(Secret)
+	}
+	return nil
+}
+
+// typedValue returns the current value of the named item as the Go type
+// that corresponds to its config type.
+func (cf *Config) typedValue(name string) interface{} {
+	switch (*cf)[name].Type {
+	case "int":
+		return cf.GetInt(name)
+	case "int64":
+		return cf.GetInt64(name)
+	case "uint":
+		return cf.GetUint(name)
+	case "float":
+		return cf.GetFloat(name)
+	case "string", "enum", "path":
+		return cf.GetString(name)
+	case "url":
+		return cf.GetURL(name)
+	case "bytesize":
+		return cf.GetByteSize(name)
+	case "map":
+		return cf.GetStringMap(name)
+	case "[]string":
+		return cf.GetStringArray(name)
+	case "bool":
+		return cf.GetFlag(name)
+	case "duration":
+		return cf.GetDuration(name)
+	case "secret":
+		return cf.GetSecret(name)
+	}
+	return nil
+}
+
+// fill copies the current values of all bound items into their fields.
+func (cf *Config) fill() {
+	for name, ag := range *cf {
+		if len(ag.targets) == 0 {
+			continue
+		}
+		if v, _ := cf.Get(name); v == nil {
+			// required but missing; Check will have complained
+			continue
+		}
+		rv := reflect.ValueOf(cf.typedValue(name))
+		for _, fv := range ag.targets {
+			fv.Set(rv.Convert(fv.Type()))
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBindDefaultConfigItems(t *testing.T) {
+	var s struct {
+		LogLevel string `rest:"LOG_LEVEL"`
+		CertFile string `rest:"TLS_CERT_FILE"`
+		Port     int    `rest:"port"`
+	}
+	cf := DefaultConfig()
+	if err := cf.Bind(&s); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.ParseArgsE([]string{"--log-level=debug"}); err != nil {
+		t.Fatal(err)
+	}
+	cf.fill()
+	if s.LogLevel != "debug" || s.CertFile != "" || s.Port != cf.GetInt("port") {
+		t.Errorf("got %+v", s)
+	}
+	if err := cf.ParseArgsE([]string{"--log-level=chatty"}); err == nil {
+		t.Error("bound enum accepted a value it doesn't allow")
+	}
+}
+
+func TestBindIncompatibleType(t *testing.T) {
+	var s struct {
+		Port string `rest:"port"`
+	}
+	if err := DefaultConfig().Bind(&s); err == nil {
+		t.Error("bound a string field to an int item")
+	}
+}
+
+func TestBindTags(t *testing.T) {
+	var s struct {
+		Port    int               `rest:"port,default=8181,help=port to listen on, if any"`
+		Name    string            `rest:"name,required"`
+		Level   string            `rest:"level,enum=low|high,default=low"`
+		Hosts   []string          `rest:"hosts,default='a,b'"`
+		Weights map[string]string `rest:"weights,default='x=1,y=2'"`
+		Wait    time.Duration
+		Skip    int `rest:"-"`
+		DB      struct {
+			User string `rest:"user,default=admin"`
+			Pool struct {
+				Size int `rest:"size,default=4"`
+			} `rest:"pool"`
+		} `rest:"db"`
+	}
+	s.Wait = 3 * time.Second
+	cf := NewConfig()
+	if err := cf.Bind(&s); err != nil {
+		t.Fatal(err)
+	}
+	checkConfigErrors(t, cf.CheckE(), []error{MissingError{}})
+	if err := cf.ParseArgsE([]string{"--name=svc", "--db-pool-size=8"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.CheckE(); err != nil {
+		t.Fatal(err)
+	}
+	cf.fill()
+	if s.Port != 8181 || s.Name != "svc" || s.Level != "low" || s.Wait != 3*time.Second {
+		t.Errorf("got %+v", s)
+	}
+	if len(s.Hosts) != 2 || s.Hosts[1] != "b" || s.Weights["y"] != "2" || len(s.Weights) != 2 {
+		t.Errorf("comma defaults: %v %v", s.Hosts, s.Weights)
+	}
+	if s.DB.User != "admin" || s.DB.Pool.Size != 8 {
+		t.Errorf("nested: %+v", s.DB)
+	}
+	if _, ok := (*cf)["SKIP"]; ok {
+		t.Error("bound a field tagged -")
+	}
+	if help := (*cf)["PORT"].Help; help != "port to listen on, if any" {
+		t.Errorf("help = %q", help)
+	}
+	if err := cf.ParseArgsE([]string{"--level=medium"}); err == nil {
+		t.Error("enum accepted a value it doesn't allow")
+	}
+}
+
+func TestBindRejectsOpaqueStructs(t *testing.T) {
+	var s struct {
+		Started time.Time
+	}
+	if err := NewConfig().Bind(&s); err == nil {
+		t.Error("bound a time.Time")
+	}
+}
@@ -0,0 +1,132 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Middleware wraps a handler in another.
+type Middleware func(http.Handler) http.Handler
+
+// These are the names of the standard middleware in the chain that
+// StandardSetup builds, from outermost to innermost. They can be used as
+// insertion points, or listed in DISABLE_MIDDLEWARE.
+const (
+	MiddlewareCORS           = "cors"
+	MiddlewareRequestID      = "requestid"
+	MiddlewareClientIdentity = "clientidentity"
+	MiddlewareRoute          = "route"
+	MiddlewareMetrics        = "metrics"
+	MiddlewareLog            = "log"
+	MiddlewareRecover        = "recover"
+	MiddlewareAuth           = "auth"
+	MiddlewareRateLimit      = "ratelimit"
+	MiddlewareDeadline       = "deadline"
+	MiddlewareThrottle       = "throttle"
+)
+
+type chainEntry struct {
+	name string
+	mw   Middleware
+}
+
+// Chain is an ordered list of named middleware, outermost first: the
+// first one sees each request before the others do.
+type Chain struct {
+	entries []chainEntry
+}
+
+func (c *Chain) index(name string) int {
+	for i, e := range c.entries {
+		if e.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Chain) insert(i int, name string, mw Middleware) error {
+	if c.index(name) >= 0 {
+		return fmt.Errorf("middleware %s is already in the chain", name)
+	}
+	c.entries = append(c.entries, chainEntry{})
+	copy(c.entries[i+1:], c.entries[i:])
+	c.entries[i] = chainEntry{name: name, mw: mw}
+	return nil
+}
+
+// Append adds middleware at the inner end of the chain, next to the
+// handler.
+func (c *Chain) Append(name string, mw Middleware) error {
+	return c.insert(len(c.entries), name, mw)
+}
+
+// InsertBefore adds middleware just outside ref, so that it sees requests
+// before ref does. For example, middleware inserted before "log" can
+// change the request that is logged.
+func (c *Chain) InsertBefore(ref, name string, mw Middleware) error {
+	i := c.index(ref)
+	if i < 0 {
+		return fmt.Errorf("no middleware named %s", ref)
+	}
+	return c.insert(i, name, mw)
+}
+
+// InsertAfter adds middleware just inside ref, so that it sees requests
+// after ref has. For example, middleware inserted after "auth" can use
+// GetPrincipal.
+func (c *Chain) InsertAfter(ref, name string, mw Middleware) error {
+	i := c.index(ref)
+	if i < 0 {
+		return fmt.Errorf("no middleware named %s", ref)
+	}
+	return c.insert(i+1, name, mw)
+}
+
+// Remove removes the named middleware, and returns false if it wasn't in
+// the chain.
+func (c *Chain) Remove(name string) bool {
+	i := c.index(name)
+	if i < 0 {
+		return false
+	}
+	c.entries = append(c.entries[:i], c.entries[i+1:]...)
+	return true
+}
+
+// Names returns the names of the middleware, outermost first.
+func (c *Chain) Names() []string {
+	names := make([]string, len(c.entries))
+	for i, e := range c.entries {
+		names[i] = e.name
+	}
+	return names
+}
+
+// Then wraps handler in the chain.
+func (c *Chain) Then(handler http.Handler) http.Handler {
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		handler = c.entries[i].mw(handler)
+	}
+	return handler
+}
+
+// MiddlewareProvider is an optional interface that a Builder can implement
+// to add its own middleware to the chain that StandardSetup builds, or to
+// rearrange it. It is called after the service is built, before anything
+// in DISABLE_MIDDLEWARE is removed. The chain handles every request, so a
+// service set up with StandardSetupMulti sees the other services'
+// requests too.
+type MiddlewareProvider interface {
+	ConfigureMiddleware(chain *Chain) error
+}
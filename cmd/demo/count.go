@@ -19,6 +19,7 @@ import (
 
 	"github.com/go-zoo/bone"
 	"github.com/ndau/ndau/pkg/ndauapi/reqres"
+	"github.com/ndau/rest"
 )
 
 // Count returns a HandlerFunc that counts from start to end
@@ -58,16 +59,19 @@ func Passthrough(u string) http.HandlerFunc {
 		first := bone.GetValue(r, "first")
 		last := bone.GetValue(r, "last")
 
-		client := http.Client{
-			Timeout: 1 * time.Second,
-		}
-		req, _ := http.NewRequest("GET", u+"/count/"+first+"/"+last, nil)
-		resp, err := client.Do(req)
+		// the request is cancelled along with ours, and DeadlineMW
+		// responds if that's because our deadline expired
+		resp, err := rest.Get(r.Context(), u+"/count/"+first+"/"+last)
 		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			rest.Logger(r).WithError(err).Error("passthrough failed")
 			reqres.RespondJSON(w, reqres.NewAPIError("bad response from passthrough", http.StatusInternalServerError))
 			return
 		}
 
+		defer resp.Body.Close()
 		body, _ := ioutil.ReadAll(resp.Body)
 		realresp := reqres.Response{
 			Bd:  body,
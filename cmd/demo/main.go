@@ -11,8 +11,6 @@ package main
 
 
 import (
-	"log"
-
 	"github.com/ndau/rest"
 )
 
@@ -20,8 +18,11 @@ func main() {
 	cf := rest.DefaultConfig()
 	// add additional config items here if desired
 	// or set new default values
-	cf.AddString("passthrough", "http://localhost:9998")
+	cf.AddString("passthrough", "http://localhost:9998", "base URL of the child service")
 	cf.SetDefault("port", 9999)
+	// the passthrough used to have its own 1s client timeout; now the whole
+	// request has a deadline, which its outbound call honors
+	cf.SetDefault("REQUEST_TIMEOUT", "1s")
 	// After this the configuration is available
 	cf.Load()
 
@@ -30,9 +31,9 @@ func main() {
 	}
 	server := rest.StandardSetup(cf, cs)
 	if server != nil {
-		// if your service requires cleanup before exiting, or can be restarted or
-		// reloaded, set up the appropriate functions and pass them here.
-		rest.WatchSignals(nil, rest.FatalFunc(cs, "SIGINT"), rest.FatalFunc(cs, "SIGTERM"))
-		log.Fatal(server.ListenAndServe())
+		// if your service requires cleanup before exiting, register it with
+		// rest.OnShutdown; Run drains in-flight requests on SIGINT or SIGTERM
+		// and then calls the hooks.
+		rest.Run(server, rest.RunOptionsFrom(cf, cs.GetLogger()))
 	}
 }
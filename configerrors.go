@@ -0,0 +1,99 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// UnknownItemError reports a command line argument or config file entry
+// that doesn't match any item in the Config.
+type UnknownItemError struct {
+	Source string // "command line" or the name of the config file
+	Arg    string
+}
+
+func (e UnknownItemError) Error() string {
+	if e.Source == "command line" {
+		return fmt.Sprintf("unrecognized command line argument: %s", e.Arg)
+	}
+	return fmt.Sprintf("unrecognized item %s in config file %s", e.Arg, e.Source)
+}
+
+// ParseError reports a value that could not be parsed as the type its
+// config item requires.
+type ParseError struct {
+	Name  string
+	Value string
+	Type  string
+	Err   error
+}
+
+func (e ParseError) Error() string {
+	msg := fmt.Sprintf("%s: could not parse %q as %s", e.Name, e.Value, e.Type)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// MissingError reports a required config item that was not specified.
+type MissingError struct {
+	Name string
+	Type string
+}
+
+func (e MissingError) Error() string {
+	return fmt.Sprintf("required %s parameter %s was not found", e.Type, e.Name)
+}
+
+// ConfigErrors collects every problem found while loading a Config, so
+// that they can all be reported at once instead of one per run.
+// Each element is usually an UnknownItemError, ParseError, or MissingError.
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// add appends err to the list, flattening nested ConfigErrors.
+func (e *ConfigErrors) add(err error) {
+	switch t := err.(type) {
+	case nil:
+	case ConfigErrors:
+		*e = append(*e, t...)
+	default:
+		*e = append(*e, err)
+	}
+}
+
+// errOrNil returns nil if there are no errors, so that callers can
+// compare the result to nil safely.
+func (e ConfigErrors) errOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// exitOnError prints err and kills the server if err is not nil.
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
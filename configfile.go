@@ -0,0 +1,176 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// readConfigFile reads a YAML, JSON, or TOML file (chosen by its extension)
+// and returns its contents as a flat map of cleaned names to raw values.
+// Nested tables are flattened by joining their keys with underscores, so
+// that `cors: {origins: [...]}` is the same as `CORS_ORIGINS: [...]`,
+// except for tables whose names satisfy isMap, which are kept whole.
+func readConfigFile(path string, isMap func(string) bool) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s: unknown format (expected .yaml, .yml, .json, or .toml)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %s", path, err)
+	}
+	flat := make(map[string]interface{})
+	flatten("", raw, flat, isMap)
+	return flat, nil
+}
+
+// stringKeys converts the nested mappings that yaml.v2 decodes with
+// interface keys into ordinary maps.
+func stringKeys(v interface{}) interface{} {
+	if t, ok := v.(map[interface{}]interface{}); ok {
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = v
+		}
+		return m
+	}
+	return v
+}
+
+// flatten copies nested maps into flat, prefixing names with their parents.
+func flatten(prefix string, m map[string]interface{}, flat map[string]interface{}, isMap func(string) bool) {
+	for k, v := range m {
+		name := clean(k)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		v = stringKeys(v)
+		if sub, ok := v.(map[string]interface{}); ok && !isMap(name) {
+			flatten(name, sub, flat, isMap)
+			continue
+		}
+		flat[name] = v
+	}
+}
+
+// fileValueString converts a value decoded from a config file to the
+// string form that parseValue understands.
+func fileValueString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		// JSON decodes all numbers as floats; don't let 8080 become 8.08e+03
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case []interface{}:
+		s := make([]string, len(t))
+		for i := range t {
+			s[i] = fileValueString(t[i])
+		}
+		return strings.Join(s, ",")
+	case map[string]interface{}:
+		m := make(map[string]string, len(t))
+		for k, v := range t {
+			m[k] = fileValueString(v)
+		}
+		return formatStringMap(m)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// fileValue converts a value decoded from a config file into the type
+// required by the config item.
+func fileValue(v interface{}, ag ConfigItem) (interface{}, error) {
+	if a, ok := v.([]interface{}); ok && ag.Type == "[]string" {
+		// keep array elements intact even if they contain commas
+		s := make([]string, len(a))
+		for i := range a {
+			s[i] = fileValueString(a[i])
+		}
+		return s, nil
+	}
+	if t, ok := v.(map[string]interface{}); ok && ag.Type == "map" {
+		// likewise for map values
+		m := make(map[string]string, len(t))
+		for k, v := range t {
+			m[k] = fileValueString(v)
+		}
+		return m, nil
+	}
+	pv, err := parseValue(fileValueString(v), ag.Type)
+	if err != nil {
+		return nil, err
+	}
+	return pv, checkItem(ag, pv)
+}
+
+// ParseFile reads a YAML, JSON, or TOML config file and stores the values it
+// finds into the Config. The format is chosen by the file's extension.
+// Names in the file are matched the same way as environment variables, and
+// nested tables become underscore-joined names. If there are any errors,
+// it logs them and kills the server.
+func (cf *Config) ParseFile(path string) {
+	exitOnError(cf.ParseFileE(path))
+}
+
+// ParseFileE is like ParseFile but returns an error instead of exiting.
+// Problems with individual items are returned as a ConfigErrors.
+func (cf *Config) ParseFileE(path string) error {
+	values, err := readConfigFile(path, func(name string) bool {
+		return (*cf)[name].Type == "map"
+	})
+	if err != nil {
+		return err
+	}
+	var errs ConfigErrors
+	// sort so that errors are reported in a stable order
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ag, ok := (*cf)[name]
+		if !ok {
+			errs.add(UnknownItemError{Source: path, Arg: name})
+			continue
+		}
+		raw := fileValueString(values[name])
+		v, err := fileValue(values[name], ag)
+		if err != nil {
+			errs.add(ParseError{Name: ag.Name, Value: raw, Type: ag.Type, Err: err})
+			continue
+		}
+		cf.store(name, v, raw, SourceFile)
+	}
+	return errs.errOrNil()
+}
@@ -0,0 +1,334 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Deadlines holds the default request deadline and per-route exceptions,
+// keyed by route pattern. A zero duration means no deadline.
+type Deadlines struct {
+	Default time.Duration
+	Routes  map[string]time.Duration
+}
+
+// For returns the deadline for the route the request matched (see RouteMW).
+func (d Deadlines) For(r *http.Request) time.Duration {
+	if t, ok := d.Routes[RoutePattern(r)]; ok {
+		return t
+	}
+	return d.Default
+}
+
+// deadlineWriter guards the response while DeadlineMW races a handler
+// against its deadline. The handler writes its headers to a copy until the
+// response starts; once DeadlineMW has sent its own response, the handler's
+// writes fail with http.ErrHandlerTimeout.
+type deadlineWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mutex    sync.Mutex
+	started  bool
+	timedOut bool
+}
+
+// start copies the handler's headers to the response, and marks it
+// started so that DeadlineMW leaves it alone. The caller must hold the
+// mutex.
+func (dw *deadlineWriter) start() {
+	if dw.started {
+		return
+	}
+	dw.started = true
+	h := dw.w.Header()
+	for k := range h {
+		if _, ok := dw.header[k]; !ok {
+			delete(h, k)
+		}
+	}
+	for k, v := range dw.header {
+		h[k] = v
+	}
+}
+
+// begin starts the response before the handler writes to it, unless it
+// has timed out.
+func (dw *deadlineWriter) begin() error {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+	if dw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+	dw.start()
+	return nil
+}
+
+// Header implements http.ResponseWriter.
+func (dw *deadlineWriter) Header() http.Header {
+	return dw.header
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (dw *deadlineWriter) WriteHeader(status int) {
+	if dw.begin() == nil {
+		dw.w.WriteHeader(status)
+	}
+}
+
+// Write implements http.ResponseWriter.
+func (dw *deadlineWriter) Write(b []byte) (int, error) {
+	if err := dw.begin(); err != nil {
+		return 0, err
+	}
+	return dw.w.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (dw *deadlineWriter) Flush() {
+	if dw.begin() != nil {
+		return
+	}
+	if f, ok := dw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (dw *deadlineWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if err := dw.begin(); err != nil {
+		return nil, nil, err
+	}
+	if hj, ok := dw.w.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Push implements http.Pusher.
+func (dw *deadlineWriter) Push(target string, opts *http.PushOptions) error {
+	dw.mutex.Lock()
+	timedOut := dw.timedOut
+	dw.mutex.Unlock()
+	if timedOut {
+		return http.ErrHandlerTimeout
+	}
+	if p, ok := dw.w.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// ReadFrom implements io.ReaderFrom.
+func (dw *deadlineWriter) ReadFrom(r io.Reader) (int64, error) {
+	if err := dw.begin(); err != nil {
+		return 0, err
+	}
+	if rf, ok := dw.w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(dw.w, r)
+}
+
+// CloseNotify implements http.CloseNotifier.
+func (dw *deadlineWriter) CloseNotify() <-chan bool {
+	if cn, ok := dw.w.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// handlerPanic carries a panic from the goroutine that DeadlineMW runs a
+// handler in, along with the stack where it happened, since the stack of
+// the re-panic doesn't show the handler.
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// String shows the original stack, for when nothing but net/http
+// recovers the panic.
+func (p handlerPanic) String() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+// panicDetails returns the value and stack of a recovered panic. The
+// stack is the current one unless the panic came through DeadlineMW.
+func panicDetails(p interface{}) (interface{}, []byte) {
+	if hp, ok := p.(handlerPanic); ok {
+		return hp.value, hp.stack
+	}
+	return p, debug.Stack()
+}
+
+// logLatePanic logs a panic from a handler that DeadlineMW had already
+// given up on.
+func logLatePanic(r *http.Request, p interface{}) {
+	value, stack := panicDetails(p)
+	Logger(r).WithFields(log.Fields{
+		"method": r.Method,
+		"uri":    r.RequestURI,
+		"panic":  fmt.Sprint(value),
+		"stack":  string(stack),
+	}).Error("panic in handler after its deadline")
+}
+
+// DeadlineMW wraps a handler and attaches the deadline for its route to
+// the request context, which is also cancelled if the client goes away.
+// Handlers should pass r.Context() to anything slow (see NewRequest and
+// Do). If the deadline expires before the handler has started its
+// response, DeadlineMW responds at once with a 504 JSON error, or a 503 if
+// the server is shutting down, even if the handler is still running; the
+// handler's later writes fail with http.ErrHandlerTimeout, and a later
+// panic is logged. A response that has already started is left to finish.
+// It must be installed inside RouteMW for per-route deadlines to work.
+func DeadlineMW(deadlines Deadlines, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout := deadlines.For(r)
+		if timeout <= 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		dw := &deadlineWriter{w: w, header: w.Header().Clone()}
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p != http.ErrAbortHandler {
+					p = handlerPanic{value: p, stack: debug.Stack()}
+				}
+				dw.mutex.Lock()
+				defer dw.mutex.Unlock()
+				if dw.timedOut {
+					// nobody is waiting to re-panic it
+					if p != http.ErrAbortHandler {
+						logLatePanic(r, p)
+					}
+					return
+				}
+				// hand it back so that RecoverMW can see it; this is done
+				// under the mutex so that DeadlineMW can't miss it
+				panicked <- p
+			}()
+			handler.ServeHTTP(dw, r.WithContext(ctx))
+			close(done)
+		}()
+		wait := func() {
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+			}
+		}
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+		case <-ctx.Done():
+		}
+		dw.mutex.Lock()
+		if dw.started || ctx.Err() != context.DeadlineExceeded {
+			// the handler either finished in time or has started its
+			// response, so let it finish
+			dw.mutex.Unlock()
+			wait()
+			dw.mutex.Lock()
+			dw.start()
+			dw.mutex.Unlock()
+			return
+		}
+		select {
+		case p := <-panicked:
+			dw.mutex.Unlock()
+			panic(p)
+		default:
+		}
+		dw.timedOut = true
+		dw.mutex.Unlock()
+		if ShuttingDown() {
+			WriteJSONError(w, http.StatusServiceUnavailable, "server is shutting down")
+			return
+		}
+		WriteJSONError(w, http.StatusGatewayTimeout, fmt.Sprintf("request timed out after %s", timeout))
+	}
+}
+
+// addDeadlineConfig adds the config items used by DeadlinesFromConfig.
+func addDeadlineConfig(cf *Config) {
+	cf.AddDuration("REQUEST_TIMEOUT", "0s", "deadline for handling each request (0 for none)")
+	cf.AddStringMap("REQUEST_TIMEOUT_ROUTES", "", "per-route request deadlines, like /count/:first/:last=500ms")
+}
+
+// DeadlinesFromConfig creates the request deadlines from the config.
+func DeadlinesFromConfig(cf *Config) (Deadlines, error) {
+	d := Deadlines{
+		Default: cf.GetDuration("REQUEST_TIMEOUT"),
+		Routes:  make(map[string]time.Duration),
+	}
+	for route, s := range cf.GetStringMap("REQUEST_TIMEOUT_ROUTES") {
+		t, err := time.ParseDuration(s)
+		if err != nil {
+			return Deadlines{}, fmt.Errorf("REQUEST_TIMEOUT_ROUTES %s: %s", route, err)
+		}
+		d.Routes[route] = t
+	}
+	return d, nil
+}
+
+// OutboundClient is the client used by Do. It has no timeout of its own;
+// requests are bounded by their context instead.
+var OutboundClient = &http.Client{}
+
+// NewRequest creates an outbound request bound to ctx (usually the context
+// of the incoming request), so that it is cancelled when the incoming
+// request's deadline expires or its client disconnects. It also passes on
+// the request ID.
+func NewRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	PropagateRequestID(ctx, req)
+	return req, nil
+}
+
+// Do sends an outbound request with OutboundClient, bound to ctx.
+func Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	req = req.WithContext(ctx)
+	PropagateRequestID(ctx, req)
+	return OutboundClient.Do(req)
+}
+
+// Get sends an outbound GET request with OutboundClient, bound to ctx.
+func Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := NewRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return OutboundClient.Do(req)
+}
@@ -0,0 +1,173 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestDeadlineMWRespondsAtDeadline(t *testing.T) {
+	release := make(chan struct{})
+	finished := make(chan error, 1)
+	h := DeadlineMW(Deadlines{Default: 50 * time.Millisecond}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ignores its context
+		<-release
+		_, err := w.Write([]byte("too late"))
+		finished <- err
+	}))
+	w := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if took := time.Since(start); took > 250*time.Millisecond {
+		t.Errorf("responded after %s", took)
+	}
+	close(release)
+	if err := <-finished; err != http.ErrHandlerTimeout {
+		t.Errorf("late write returned %v", err)
+	}
+	if w.Code != http.StatusGatewayTimeout || strings.Contains(w.Body.String(), "too late") {
+		t.Errorf("got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestDeadlineMWLeavesStartedResponses(t *testing.T) {
+	h := DeadlineMW(Deadlines{Default: 20 * time.Millisecond}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		w.Write([]byte("data: 2\n\n"))
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "data: 1\n\ndata: 2\n\n" || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("got %d %q %v", w.Code, w.Body, w.Header())
+	}
+}
+
+func TestDeadlineMWPassesOnHeadersAndPanics(t *testing.T) {
+	h := DeadlineMW(Deadlines{Default: time.Second}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "yes")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("X-Test") != "yes" {
+		t.Errorf("header lost: %v", w.Header())
+	}
+
+	h = DeadlineMW(Deadlines{Default: time.Second}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	defer func() {
+		value, stack := panicDetails(recover())
+		if value != "boom" {
+			t.Errorf("recovered %v", value)
+		}
+		// the stack is the handler's, not DeadlineMW's
+		if !strings.Contains(string(stack), "TestDeadlineMWPassesOnHeadersAndPanics.func2") {
+			t.Errorf("stack doesn't show the handler:\n%s", stack)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+// logTo returns a logger that writes JSON to out.
+func logTo(out io.Writer) *log.Logger {
+	logger := log.New()
+	logger.Out = out
+	logger.Formatter = &log.JSONFormatter{}
+	return logger
+}
+
+func TestDeadlineMWRecoveredStack(t *testing.T) {
+	var buf bytes.Buffer
+	h := RequestIDMW(logTo(&buf), RecoverMW(nil, DeadlineMW(Deadlines{Default: time.Second}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got %d", w.Code)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["panic"] != "boom" || !strings.Contains(entry["stack"].(string), "TestDeadlineMWRecoveredStack.func1") {
+		t.Errorf("logged %v", entry)
+	}
+}
+
+func TestDeadlineMWLatePanic(t *testing.T) {
+	var buf syncBuffer
+	release := make(chan struct{})
+	done := make(chan struct{})
+	h := RequestIDMW(logTo(&buf), DeadlineMW(Deadlines{Default: 20 * time.Millisecond}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		<-release
+		panic("late")
+	})))
+	w := httptest.NewRecorder()
+	// the panic comes after DeadlineMW has returned, so it mustn't
+	// reach here
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("got %d", w.Code)
+	}
+	close(release)
+	<-done
+	// the log is written after the handler's deferred functions run
+	for i := 0; i < 100 && buf.Len() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("%v: %q", err, buf.Bytes())
+	}
+	if entry["msg"] != "panic in handler after its deadline" || entry["panic"] != "late" ||
+		!strings.Contains(entry["stack"].(string), "TestDeadlineMWLatePanic.func1") {
+		t.Errorf("logged %v", entry)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be written and read concurrently.
+type syncBuffer struct {
+	mutex sync.Mutex
+	bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *syncBuffer) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Buffer.Len()
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]byte(nil), b.Buffer.Bytes()...)
+}
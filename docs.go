@@ -0,0 +1,294 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kentquirk/boneful"
+	"github.com/russross/blackfriday/v2"
+)
+
+// These are the formats that the docs can be served in.
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+	FormatJSON     = "json"
+)
+
+// DocRoute is a route in the JSON form of the docs.
+type DocRoute struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// ServiceDocs is the documentation for one service.
+type ServiceDocs struct {
+	// Name is the service's root path, or the URL it was fetched from.
+	Name     string     `json:"name"`
+	Markdown string     `json:"markdown"`
+	Routes   []DocRoute `json:"routes"`
+	// Error is set if the docs couldn't be fetched from a sibling service.
+	Error string `json:"error,omitempty"`
+}
+
+// DocsIndex is the merged documentation for several services.
+type DocsIndex struct {
+	Services []ServiceDocs `json:"services"`
+}
+
+// NewServiceDocs collects the documentation for svc, which is served at
+// rootpath.
+func NewServiceDocs(svc *boneful.Service, rootpath string) ServiceDocs {
+	buf := &bytes.Buffer{}
+	svc.GenerateDocumentation(buf)
+	sd := ServiceDocs{Name: rootpath, Markdown: buf.String()}
+	for method, routes := range svc.Mux().Routes {
+		for _, r := range routes {
+			sd.Routes = append(sd.Routes, DocRoute{Method: method, Path: r.Path})
+		}
+	}
+	sort.Slice(sd.Routes, func(i, j int) bool {
+		if sd.Routes[i].Path != sd.Routes[j].Path {
+			return sd.Routes[i].Path < sd.Routes[j].Path
+		}
+		return sd.Routes[i].Method < sd.Routes[j].Method
+	})
+	return sd
+}
+
+// docsMediaTypes maps the media types that can be negotiated to formats.
+var docsMediaTypes = map[string]string{
+	"text/html":        FormatHTML,
+	"text/markdown":    FormatMarkdown,
+	"text/x-markdown":  FormatMarkdown,
+	"text/plain":       FormatMarkdown,
+	"application/json": FormatJSON,
+}
+
+// NegotiateFormat picks the docs format for a request: the format query
+// parameter (html, markdown or md, json) if present, or else the
+// acceptable type in the Accept header with the highest quality.
+// It defaults to markdown, which is what the docs are written in.
+func NegotiateFormat(r *http.Request) string {
+	switch r.URL.Query().Get("format") {
+	case "html":
+		return FormatHTML
+	case "markdown", "md":
+		return FormatMarkdown
+	case "json":
+		return FormatJSON
+	}
+	best, bestQ := FormatMarkdown, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		fields := strings.Split(part, ";")
+		format, ok := docsMediaTypes[strings.ToLower(strings.TrimSpace(fields[0]))]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// markdown joins the docs of several services, each under a heading if
+// there is more than one.
+func (di DocsIndex) markdown() string {
+	if len(di.Services) == 1 {
+		return di.Services[0].Markdown
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("# Services\n\n")
+	for _, sd := range di.Services {
+		fmt.Fprintf(buf, "* [%s](#%s)\n", sd.Name, anchor(sd.Name))
+	}
+	for _, sd := range di.Services {
+		fmt.Fprintf(buf, "\n<a name=\"%s\"></a>\n\n# %s\n\n", anchor(sd.Name), sd.Name)
+		if sd.Error != "" {
+			fmt.Fprintf(buf, "Documentation unavailable: %s\n", sd.Error)
+			continue
+		}
+		buf.WriteString(sd.Markdown)
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// anchor turns a service name into an HTML anchor name.
+func anchor(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// WriteDocs writes the docs in the given format.
+func (di DocsIndex) WriteDocs(w http.ResponseWriter, format string) {
+	switch format {
+	case FormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if len(di.Services) == 1 {
+			enc.Encode(di.Services[0])
+			return
+		}
+		enc.Encode(di)
+	case FormatHTML:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		title := "API documentation"
+		if len(di.Services) == 1 {
+			title += " for " + di.Services[0].Name
+		}
+		fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n", html.EscapeString(title))
+		w.Write(blackfriday.Run([]byte(di.markdown())))
+		fmt.Fprint(w, "</body>\n</html>\n")
+	default:
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		fmt.Fprint(w, di.markdown())
+	}
+}
+
+// DocsHandler returns a handler that serves the docs for one or more
+// services as HTML, Markdown, or JSON, as negotiated by NegotiateFormat.
+func DocsHandler(docs ...ServiceDocs) http.Handler {
+	di := DocsIndex{Services: docs}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		di.WriteDocs(w, NegotiateFormat(r))
+	})
+}
+
+// localDocsHeader is set on requests for a sibling's docs, asking it to
+// leave out its own siblings, so that services that list each other don't
+// fetch each other's docs forever.
+const localDocsHeader = "X-Docs-Local"
+
+// maxDocsSize is the most that is read from a sibling's docs.
+const maxDocsSize = 4 << 20
+
+// fetchDocs gets the JSON docs from a sibling service's docs URL. The
+// sibling can serve one service (ServiceDocs) or several (DocsIndex).
+func fetchDocs(ctx context.Context, url string) []ServiceDocs {
+	failed := func(err string) []ServiceDocs {
+		return []ServiceDocs{{Name: url, Error: err}}
+	}
+	req, err := NewRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return failed(err.Error())
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(localDocsHeader, "true")
+	resp, err := OutboundClient.Do(req)
+	if err != nil {
+		return failed(err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDocsSize+1))
+	if err != nil {
+		return failed(err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return failed(resp.Status)
+	}
+	if len(body) > maxDocsSize {
+		return failed(fmt.Sprintf("docs are larger than %d bytes", maxDocsSize))
+	}
+	var docs struct {
+		ServiceDocs
+		Services []ServiceDocs `json:"services"`
+	}
+	if err := json.Unmarshal(body, &docs); err != nil {
+		return failed(err.Error())
+	}
+	// keep the URL in the name so that services with the same root path
+	// can be told apart
+	if docs.Services == nil {
+		docs.ServiceDocs.Name = url
+		return []ServiceDocs{docs.ServiceDocs}
+	}
+	for i := range docs.Services {
+		docs.Services[i].Name = url + " " + docs.Services[i].Name
+	}
+	return docs.Services
+}
+
+// AggregateDocsHandler returns a handler that serves the docs for the
+// local services merged with the docs of their siblings. Each of siblings
+// is the full URL of a service's docs endpoint, which is fetched (as JSON)
+// giving up after timeout. The siblings' docs are cached for cacheTTL, so
+// that requests for the docs don't all fan out to every sibling. A sibling
+// that can't be reached is listed with its error. Siblings are trusted:
+// their Markdown is rendered into the HTML as is.
+func AggregateDocsHandler(local []ServiceDocs, siblings []string, timeout, cacheTTL time.Duration) http.Handler {
+	var (
+		mutex   sync.Mutex
+		cached  []ServiceDocs
+		fetched time.Time
+	)
+	fetchAll := func() []ServiceDocs {
+		// the fetch is shared, so it isn't tied to any one request
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		results := make([][]ServiceDocs, len(siblings))
+		var wg sync.WaitGroup
+		for i, url := range siblings {
+			wg.Add(1)
+			go func(i int, url string) {
+				defer wg.Done()
+				results[i] = fetchDocs(ctx, url)
+			}(i, url)
+		}
+		wg.Wait()
+		var all []ServiceDocs
+		for _, r := range results {
+			all = append(all, r...)
+		}
+		return all
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(localDocsHeader) != "" {
+			DocsIndex{Services: local}.WriteDocs(w, NegotiateFormat(r))
+			return
+		}
+		mutex.Lock()
+		if cached == nil || time.Since(fetched) >= cacheTTL {
+			cached, fetched = fetchAll(), time.Now()
+		}
+		remote := cached
+		mutex.Unlock()
+		di := DocsIndex{Services: make([]ServiceDocs, 0, len(local)+len(remote))}
+		di.Services = append(append(di.Services, local...), remote...)
+		di.WriteDocs(w, NegotiateFormat(r))
+	})
+}
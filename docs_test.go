@@ -0,0 +1,77 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func getDocsIndex(t *testing.T, h http.Handler) DocsIndex {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/docs?format=json", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var di DocsIndex
+	if err := json.Unmarshal(w.Body.Bytes(), &di); err != nil {
+		t.Fatalf("%s: %s", err, w.Body)
+	}
+	return di
+}
+
+func TestAggregateDocs(t *testing.T) {
+	var hits int32
+	single := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		DocsHandler(ServiceDocs{Name: "/one", Markdown: "# one"}).ServeHTTP(w, r)
+	}))
+	defer single.Close()
+	// a sibling that aggregates its own siblings, including us
+	multi := httptest.NewServer(AggregateDocsHandler(
+		[]ServiceDocs{{Name: "/two"}, {Name: "/three"}},
+		[]string{single.URL},
+		time.Second, time.Minute,
+	))
+	defer multi.Close()
+	huge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name": "`))
+		w.Write([]byte(strings.Repeat("x", maxDocsSize)))
+		w.Write([]byte(`"}`))
+	}))
+	defer huge.Close()
+
+	h := AggregateDocsHandler([]ServiceDocs{{Name: "/local"}}, []string{single.URL, multi.URL, huge.URL}, time.Second, time.Minute)
+	di := getDocsIndex(t, h)
+	var names []string
+	for _, sd := range di.Services {
+		names = append(names, sd.Name)
+	}
+	want := []string{"/local", single.URL, multi.URL + " /two", multi.URL + " /three", huge.URL}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+	if di.Services[1].Markdown != "# one" {
+		t.Errorf("got %+v", di.Services[1])
+	}
+	if !strings.Contains(di.Services[4].Error, "larger") {
+		t.Errorf("oversized docs gave %+v", di.Services[4].Error)
+	}
+
+	getDocsIndex(t, h)
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("sibling was fetched %d times", n)
+	}
+}
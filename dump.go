@@ -0,0 +1,130 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"text/tabwriter"
+	"time"
+)
+
+// redacted is displayed in place of the value of a secret config item.
+const redacted = "[REDACTED]"
+
+// DumpItem is the externally-visible form of a config item, used by Dump.
+type DumpItem struct {
+	Name    string       `json:"name"`
+	Env     string       `json:"env"`
+	Type    string       `json:"type"`
+	Value   interface{}  `json:"value"`
+	Default interface{}  `json:"default"`
+	Source  ConfigSource `json:"source"`
+	Raw     string       `json:"raw,omitempty"`
+	Secret  bool         `json:"secret,omitempty"`
+}
+
+// dumpValue converts a config value into something that is readable when
+// marshalled; durations would otherwise come out as nanoseconds.
+func dumpValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case time.Duration:
+		return t.String()
+	case ByteSize:
+		return t.String()
+	case *url.URL:
+		// don't show passwords embedded in URLs
+		return t.Redacted()
+	}
+	return v
+}
+
+// DumpItems returns the effective value and provenance of every config
+// item, sorted by name, with the values of secret items redacted.
+func (cf *Config) DumpItems() []DumpItem {
+	snap := cf.snapshot()
+	items := make([]DumpItem, 0, len(snap))
+	for _, name := range snap.names() {
+		ag := snap[name]
+		v, _ := snap.get(name)
+		di := DumpItem{
+			Name:    ag.Name,
+			Env:     clean(ag.Name),
+			Type:    ag.Type,
+			Value:   dumpValue(v),
+			Default: dumpValue(ag.Default),
+			Source:  ag.Source,
+			Raw:     ag.Raw,
+			Secret:  ag.Secret,
+		}
+		if di.Source == "" {
+			di.Source = SourceDefault
+		}
+		if u, err := url.Parse(di.Raw); err == nil && ag.Type == "url" {
+			di.Raw = u.Redacted()
+		}
+		if ag.Secret {
+			di.Value = redacted
+			di.Default = redacted
+			if ag.Source != "" {
+				di.Raw = redacted
+			}
+		}
+		items = append(items, di)
+	}
+	return items
+}
+
+// Dump writes the effective config to w, showing where each value came
+// from. The format is either "text" (a table) or "json". Secret items
+// are redacted.
+func (cf *Config) Dump(w io.Writer, format string) error {
+	items := cf.DumpItems()
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(items)
+	case "text", "":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tTYPE\tSOURCE\tVALUE\tRAW")
+		for _, di := range items {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", di.Env, di.Type, di.Source, formatValue(di.Value), di.Raw)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown config dump format %q (expected text or json)", format)
+	}
+}
+
+// DumpHandler returns a handler that serves the effective config.
+// It returns JSON unless the query string asks for format=text.
+func (cf *Config) DumpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		switch format {
+		case "json":
+			w.Header().Set("Content-Type", "application/json")
+		case "text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		default:
+			http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+			return
+		}
+		cf.Dump(w, format)
+	})
+}
@@ -0,0 +1,173 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthCheck is a named readiness check. Check should return nil if the
+// dependency it checks is usable, and should respect ctx's deadline.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthChecker is an optional interface that a Builder can implement to
+// add readiness checks (database connections, downstream services, and
+// so on) to /health/ready.
+type HealthChecker interface {
+	HealthChecks() []HealthCheck
+}
+
+// CheckResult is the outcome of one health check.
+type CheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is the body of a health response.
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+// Health status values.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// errShuttingDown is reported by the built-in shutdown check.
+var errShuttingDown = errors.New("service is shutting down")
+
+// Health serves liveness and readiness endpoints.
+type Health struct {
+	mutex   sync.RWMutex
+	checks  []HealthCheck
+	timeout time.Duration
+}
+
+// NewHealth creates a Health whose readiness checks must finish within
+// timeout. It always includes a check that fails once graceful shutdown
+// has begun, so that load balancers stop sending traffic.
+func NewHealth(timeout time.Duration) *Health {
+	h := &Health{timeout: timeout}
+	h.AddCheck("shutdown", func(ctx context.Context) error {
+		if ShuttingDown() {
+			return errShuttingDown
+		}
+		return nil
+	})
+	return h
+}
+
+// AddCheck adds a readiness check.
+func (h *Health) AddCheck(name string, check func(ctx context.Context) error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.checks = append(h.checks, HealthCheck{Name: name, Check: check})
+}
+
+// AddChecker adds all the checks from a HealthChecker.
+func (h *Health) AddChecker(hc HealthChecker) {
+	for _, c := range hc.HealthChecks() {
+		h.AddCheck(c.Name, c.Check)
+	}
+}
+
+// Ready runs all the readiness checks concurrently and reports the results.
+func (h *Health) Ready(ctx context.Context) HealthReport {
+	h.mutex.RLock()
+	checks := append([]HealthCheck(nil), h.checks...)
+	h.mutex.RUnlock()
+
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	report := HealthReport{Status: StatusOK, Checks: make([]CheckResult, len(checks))}
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Checks[i] = runCheck(ctx, checks[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, r := range report.Checks {
+		if r.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// runCheck runs one check, giving up when ctx expires even if the check
+// doesn't.
+func runCheck(ctx context.Context, hc HealthCheck) CheckResult {
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- hc.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	r := CheckResult{
+		Name:      hc.Name,
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		r.Status = StatusFail
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// writeReport writes a health report as JSON, with a 503 status if it failed.
+func writeReport(w http.ResponseWriter, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// LiveHandler returns a handler that reports whether the process is alive.
+// It succeeds whenever the server can respond at all.
+func (h *Health) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, HealthReport{Status: StatusOK})
+	})
+}
+
+// ReadyHandler returns a handler that reports whether the service is ready
+// to receive traffic, with the result of each readiness check.
+func (h *Health) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, h.Ready(r.Context()))
+	})
+}
@@ -0,0 +1,122 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readyResponse serves a readiness request and decodes the report.
+func readyResponse(t *testing.T, h *Health) (int, HealthReport) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ReadyHandler().ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+	var report HealthReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, report
+}
+
+// result returns the named check's result from a report.
+func result(report HealthReport, name string) CheckResult {
+	for _, r := range report.Checks {
+		if r.Name == name {
+			return r
+		}
+	}
+	return CheckResult{}
+}
+
+func TestReady(t *testing.T) {
+	resetLifecycle(t)
+	h := NewHealth(time.Second)
+	h.AddCheck("db", func(ctx context.Context) error { return nil })
+	code, report := readyResponse(t, h)
+	if code != http.StatusOK || report.Status != StatusOK || len(report.Checks) != 2 {
+		t.Errorf("got %d %+v", code, report)
+	}
+
+	h.AddCheck("cache", func(ctx context.Context) error { return errors.New("no connection") })
+	code, report = readyResponse(t, h)
+	if code != http.StatusServiceUnavailable || report.Status != StatusFail {
+		t.Errorf("got %d %+v", code, report)
+	}
+	if r := result(report, "cache"); r.Status != StatusFail || r.Error != "no connection" {
+		t.Errorf("cache check: %+v", r)
+	}
+	if r := result(report, "db"); r.Status != StatusOK {
+		t.Errorf("db check: %+v", r)
+	}
+}
+
+func TestReadyTimeout(t *testing.T) {
+	resetLifecycle(t)
+	h := NewHealth(50 * time.Millisecond)
+	// this check ignores its context, so it must be abandoned
+	release := make(chan struct{})
+	defer close(release)
+	h.AddCheck("stuck", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	// this one respects its context
+	h.AddCheck("slow", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Minute):
+			return nil
+		}
+	})
+	h.AddCheck("fast", func(ctx context.Context) error { return nil })
+
+	start := time.Now()
+	code, report := readyResponse(t, h)
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("readiness took %s with a 50ms timeout", took)
+	}
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("got %d", code)
+	}
+	for _, name := range []string{"stuck", "slow"} {
+		if r := result(report, name); r.Status != StatusFail || r.Error != context.DeadlineExceeded.Error() {
+			t.Errorf("%s check: %+v", name, r)
+		}
+	}
+	if r := result(report, "fast"); r.Status != StatusOK {
+		t.Errorf("fast check: %+v", r)
+	}
+}
+
+func TestReadyDuringShutdown(t *testing.T) {
+	resetLifecycle(t)
+	h := NewHealth(time.Second)
+	atomic.StoreInt32(&shuttingDown, 1)
+	code, report := readyResponse(t, h)
+	if code != http.StatusServiceUnavailable || result(report, "shutdown").Error != errShuttingDown.Error() {
+		t.Errorf("got %d %+v", code, report)
+	}
+
+	// liveness doesn't depend on readiness
+	w := httptest.NewRecorder()
+	h.LiveHandler().ServeHTTP(w, httptest.NewRequest("GET", "/health/live", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("live got %d", w.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// ErrHelp is returned by LoadE when --help (or -h) was given on the
+// command line.
+var ErrHelp = errors.New("help requested")
+
+// isHelp returns true if name is a request for help rather than
+// the name of a config item.
+func (cf *Config) isHelp(name string) bool {
+	if name != "HELP" && name != "H" {
+		return false
+	}
+	_, ok := (*cf)[name]
+	return !ok
+}
+
+// helpRequested returns true if args contains --help or -h.
+func (cf *Config) helpRequested(args []string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") && cf.isHelp(clean(strings.TrimLeft(arg, "-"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// flagForm returns the command line form of a config item.
+func flagForm(ag ConfigItem) string {
+	flag := "--" + strings.ToLower(strings.Replace(clean(ag.Name), "_", "-", -1))
+	meta := strings.ToUpper(ag.Type)
+	switch ag.Type {
+	case "bool":
+		return flag + "[=" + meta + "]"
+	case "[]string":
+		meta = "A,B,..."
+	case "map":
+		meta = "K=V,..."
+	case "enum":
+		meta = strings.Join(ag.Allowed, "|")
+	}
+	return flag + "=" + meta
+}
+
+// formatValue formats a config value for display.
+func formatValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case []string:
+		return strings.Join(t, ",")
+	case map[string]string:
+		return formatStringMap(t)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Usage writes a description of the service and a table of every config
+// item to w: its command line form, environment variable, type, default,
+// whether it's required, its current effective value, and its description.
+func (cf *Config) Usage(w io.Writer, service string) {
+	snap := cf.snapshot()
+	fmt.Fprintf(w, "Usage: %s [--name=value ...]\n\n", service)
+	fmt.Fprintln(w, "Each setting can be given on the command line or as an environment variable")
+	fmt.Fprintln(w, "(the command line wins).")
+	if _, ok := snap["CONFIG"]; ok {
+		fmt.Fprintln(w, "Settings can also be read from a YAML, JSON, or TOML file with --config=FILE.")
+	}
+	if _, ok := snap["DOCS"]; ok {
+		fmt.Fprintf(w, "To write the API documentation for %s instead of serving, use --docs=FILE\n", service)
+		fmt.Fprintln(w, "(or --docs=- for stdout).")
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FLAG\tENV\tTYPE\tDEFAULT\tREQUIRED\tVALUE\tDESCRIPTION")
+	for _, name := range snap.names() {
+		ag := snap[name]
+		required := ""
+		if ag.Default == nil {
+			required = "yes"
+		}
+		v, _ := snap.get(name)
+		env, def, val := clean(ag.Name), formatValue(ag.Default), formatValue(v)
+		if ag.Type == "secret" {
+			env += ", " + env + "_FILE"
+		}
+		if ag.Secret {
+			def, val = "", redacted
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			flagForm(ag), env, ag.Type, def, required, val, ag.Help)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,173 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// These headers carry an HMAC request signature.
+const (
+	HMACKeyHeader       = "X-Auth-Key"
+	HMACTimestampHeader = "X-Auth-Timestamp"
+	HMACSignatureHeader = "X-Auth-Signature"
+)
+
+// maxSignedBody limits how much of a signed request's body is read to
+// check its signature.
+const maxSignedBody = 10 << 20
+
+// HMACAuth authenticates requests signed with a shared secret. The client
+// sends its key ID, a unix timestamp, and the lower-case hex HMAC-SHA256
+// of the string to sign (see StringToSign). Requests whose timestamp is
+// more than window from now are rejected, as are repeats of a signature
+// within the window.
+type HMACAuth struct {
+	keys   map[string][]byte
+	window time.Duration
+
+	mutex sync.Mutex
+	seen  map[string]time.Time
+	// order holds the keys of seen in the order they were added, so that
+	// expired ones can be dropped without scanning the whole map
+	order []seenSignature
+}
+
+// seenSignature is an entry in HMACAuth's replay cache.
+type seenSignature struct {
+	key string
+	at  time.Time
+}
+
+// NewHMACAuth creates an HMACAuth from a map of key IDs to secrets.
+func NewHMACAuth(keys map[string]string, window time.Duration) *HMACAuth {
+	ha := &HMACAuth{
+		keys:   make(map[string][]byte, len(keys)),
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+	for id, secret := range keys {
+		ha.keys[id] = []byte(secret)
+	}
+	return ha
+}
+
+// StringToSign returns the string that a request's signature covers: the
+// method, the path and query, the timestamp, and the hex SHA-256 of the
+// body, separated by newlines.
+func StringToSign(method, uri, timestamp string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return method + "\n" + uri + "\n" + timestamp + "\n" + hex.EncodeToString(sum[:])
+}
+
+// SignRequest signs an outbound request for HMACAuth. The body is read
+// and replaced so it can still be sent.
+func SignRequest(r *http.Request, id, secret string, now time.Time) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(StringToSign(r.Method, r.URL.RequestURI(), ts, body)))
+	r.Header.Set(HMACKeyHeader, id)
+	r.Header.Set(HMACTimestampHeader, ts)
+	r.Header.Set(HMACSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// Authenticate implements Authenticator. It reads the request body, and
+// replaces it so that the handler can read it too.
+func (ha *HMACAuth) Authenticate(r *http.Request) (*Principal, error) {
+	id := r.Header.Get(HMACKeyHeader)
+	sig := r.Header.Get(HMACSignatureHeader)
+	if id == "" && sig == "" {
+		return nil, ErrNoCredentials
+	}
+	secret, ok := ha.keys[id]
+	if !ok {
+		return nil, errors.New("unknown HMAC key")
+	}
+	ts := r.Header.Get(HMACTimestampHeader)
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, errors.New("bad timestamp")
+	}
+	now := time.Now()
+	age := now.Sub(time.Unix(unix, 0))
+	if age > ha.window || age < -ha.window {
+		return nil, errors.New("timestamp outside the allowed window")
+	}
+	given, err := hex.DecodeString(sig)
+	if err != nil || hex.EncodeToString(given) != sig {
+		// only lower case is accepted, so that each signature has one
+		// spelling for the replay check
+		return nil, errors.New("bad signature")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxSignedBody))
+		if err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(StringToSign(r.Method, r.URL.RequestURI(), ts, body)))
+	if !hmac.Equal(given, mac.Sum(nil)) {
+		return nil, errors.New("signature mismatch")
+	}
+	if !ha.remember(id+":"+hex.EncodeToString(given), now) {
+		return nil, errors.New("replayed request")
+	}
+	return &Principal{ID: id, Method: "hmac"}, nil
+}
+
+// remember records a signature, returning false if it was already seen
+// within the window. Expired entries are dropped from the front of the
+// order as it goes; a signature can't be replayed once it has expired,
+// because its timestamp is then outside the window too.
+func (ha *HMACAuth) remember(key string, now time.Time) bool {
+	ha.mutex.Lock()
+	defer ha.mutex.Unlock()
+	expired := 0
+	for _, s := range ha.order {
+		if now.Sub(s.at) <= 2*ha.window {
+			break
+		}
+		delete(ha.seen, s.key)
+		expired++
+	}
+	ha.order = ha.order[expired:]
+	if _, ok := ha.seen[key]; ok {
+		return false
+	}
+	ha.seen[key] = now
+	ha.order = append(ha.order, seenSignature{key: key, at: now})
+	return true
+}
@@ -0,0 +1,217 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jwtMethods are the only signing algorithms accepted, so that a token
+// can't pick a weaker one (or "none").
+var jwtMethods = []string{"HS256", "RS256", "ES256"}
+
+// jwkTypes maps each of jwtMethods to the key type it needs.
+var jwkTypes = map[string]string{"HS256": "oct", "RS256": "RSA", "ES256": "EC"}
+
+// JWK is a single key from a JSON Web Key Set. Only the fields needed for
+// oct (HMAC), RSA, and EC P-256 keys are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	// oct
+	K string `json:"k,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func decodeB64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// key converts the JWK to a key that the jwt package can verify with.
+func (k JWK) key() (interface{}, error) {
+	if k.Alg != "" {
+		kty, ok := jwkTypes[k.Alg]
+		if !ok {
+			return nil, fmt.Errorf("key %s: unsupported algorithm %s", k.Kid, k.Alg)
+		}
+		if kty != k.Kty {
+			return nil, fmt.Errorf("key %s: algorithm %s doesn't match key type %s", k.Kid, k.Alg, k.Kty)
+		}
+	}
+	switch k.Kty {
+	case "oct":
+		b, err := decodeB64(k.K)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("key %s: bad k", k.Kid)
+		}
+		return b, nil
+	case "RSA":
+		n, err := decodeB64(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: bad n", k.Kid)
+		}
+		e, err := decodeB64(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: bad e", k.Kid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("key %s: unsupported curve %s", k.Kid, k.Crv)
+		}
+		x, err := decodeB64(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: bad x", k.Kid)
+		}
+		y, err := decodeB64(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: bad y", k.Kid)
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("key %s: point is not on the curve", k.Kid)
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("key %s: unsupported key type %s", k.Kid, k.Kty)
+}
+
+// JWTAuth authenticates requests with bearer JWTs signed by one of the
+// keys in a JWKS. The principal is the token's subject.
+type JWTAuth struct {
+	keys map[string]interface{}
+	// algs holds the algorithms of the keys whose JWK named one
+	algs     map[string]string
+	issuer   string
+	audience string
+}
+
+// NewJWTAuth creates a JWTAuth that verifies tokens with the keys in jwks.
+// If issuer or audience aren't empty, tokens must have matching iss or aud
+// claims.
+func NewJWTAuth(jwks JWKS, issuer, audience string) (*JWTAuth, error) {
+	ja := &JWTAuth{
+		keys:     make(map[string]interface{}),
+		algs:     make(map[string]string),
+		issuer:   issuer,
+		audience: audience,
+	}
+	for _, k := range jwks.Keys {
+		key, err := k.key()
+		if err != nil {
+			return nil, err
+		}
+		ja.keys[k.Kid] = key
+		if k.Alg != "" {
+			ja.algs[k.Kid] = k.Alg
+		}
+	}
+	if len(ja.keys) == 0 {
+		return nil, errors.New("JWKS has no keys")
+	}
+	return ja, nil
+}
+
+// NewJWTAuthFromFile creates a JWTAuth from a JWKS file.
+func NewJWTAuthFromFile(path, issuer, audience string) (*JWTAuth, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jwks JWKS
+	if err := json.Unmarshal(b, &jwks); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return NewJWTAuth(jwks, issuer, audience)
+}
+
+// keyFunc finds the key for a token by its kid header. If the set has
+// only one key, tokens don't need a kid.
+func (ja *JWTAuth) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, ok := ja.keys[kid]
+	if !ok && kid == "" && len(ja.keys) == 1 {
+		for id, k := range ja.keys {
+			kid, key, ok = id, k, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if alg, ok := ja.algs[kid]; ok && t.Method.Alg() != alg {
+		return nil, errors.New("algorithm doesn't match key")
+	}
+	// make sure the key type matches the algorithm, so an RSA public key
+	// can't be used as an HMAC secret
+	switch key.(type) {
+	case []byte:
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("algorithm doesn't match key")
+		}
+	case *rsa.PublicKey:
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errors.New("algorithm doesn't match key")
+		}
+	case *ecdsa.PublicKey:
+		if _, ok := t.Method.(*jwt.SigningMethodECDSA); !ok {
+			return nil, errors.New("algorithm doesn't match key")
+		}
+	}
+	return key, nil
+}
+
+// Authenticate implements Authenticator.
+func (ja *JWTAuth) Authenticate(r *http.Request) (*Principal, error) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return nil, ErrNoCredentials
+	}
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(jwtMethods))
+	if _, err := parser.ParseWithClaims(strings.TrimSpace(h[7:]), claims, ja.keyFunc); err != nil {
+		return nil, err
+	}
+	if ja.issuer != "" && !claims.VerifyIssuer(ja.issuer, true) {
+		return nil, errors.New("wrong issuer")
+	}
+	if ja.audience != "" && !claims.VerifyAudience(ja.audience, true) {
+		return nil, errors.New("wrong audience")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return &Principal{ID: sub, Method: "jwt", Claims: claims}, nil
+}
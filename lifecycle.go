@@ -0,0 +1,297 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Exit codes used by Run.
+const (
+	// ExitOK means the server was shut down by a signal and drained cleanly.
+	ExitOK = 0
+	// ExitServeError means the server could not start or stopped on its own.
+	ExitServeError = 1
+	// ExitShutdownError means the server was asked to shut down, but draining
+	// timed out or a shutdown hook failed.
+	ExitShutdownError = 2
+)
+
+// RunOptions controls how Run and Serve manage the server's lifecycle.
+type RunOptions struct {
+	// Logger receives lifecycle messages; it defaults to the standard logger.
+	Logger log.FieldLogger
+	// ShutdownDelay is how long to wait after a shutdown signal before
+	// refusing new connections, so that load balancers have time to notice
+	// that the service is no longer ready.
+	ShutdownDelay time.Duration
+	// ShutdownTimeout is how long to wait for in-flight requests to finish,
+	// and separately, how long the shutdown hooks have to run.
+	// Zero means wait forever.
+	ShutdownTimeout time.Duration
+	// Signals are the signals that start a graceful shutdown; they default
+	// to SIGINT and SIGTERM.
+	Signals []os.Signal
+	// Bindings are the listeners to serve on, possibly for several servers.
+	// If it's empty, Serve uses the ones set up by StandardSetup, or else
+	// listens on the server's Addr.
+	Bindings []Binding
+}
+
+// RunOptionsFrom creates RunOptions from the SHUTDOWN_DELAY and
+// SHUTDOWN_TIMEOUT config items.
+func RunOptionsFrom(cf *Config, logger log.FieldLogger) RunOptions {
+	return RunOptions{
+		Logger:          logger,
+		ShutdownDelay:   cf.GetDuration("SHUTDOWN_DELAY"),
+		ShutdownTimeout: cf.GetDuration("SHUTDOWN_TIMEOUT"),
+	}
+}
+
+// ServeError is returned by Serve when the server fails on its own.
+type ServeError struct {
+	Err error
+}
+
+func (e ServeError) Error() string {
+	return fmt.Sprintf("server failed: %s", e.Err)
+}
+
+// ShutdownError is returned by Serve when a graceful shutdown doesn't
+// complete cleanly. It lists every problem encountered.
+type ShutdownError struct {
+	Errs []error
+}
+
+func (e ShutdownError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i := range e.Errs {
+		msgs[i] = e.Errs[i].Error()
+	}
+	return fmt.Sprintf("shutdown incomplete: %s", strings.Join(msgs, "; "))
+}
+
+// ExitCode returns the process exit code that corresponds to an error
+// returned by Serve.
+func ExitCode(err error) int {
+	switch err.(type) {
+	case nil:
+		return ExitOK
+	case ShutdownError:
+		return ExitShutdownError
+	default:
+		return ExitServeError
+	}
+}
+
+var shutdownHooks struct {
+	sync.Mutex
+	hooks []func(ctx context.Context) error
+}
+
+// OnShutdown registers a function to be called during graceful shutdown,
+// after the server has stopped handling requests. Hooks are called in the
+// reverse of the order they were registered (so resources are released in
+// the opposite order they were acquired), and ctx expires after the
+// shutdown timeout.
+func OnShutdown(f func(ctx context.Context) error) {
+	shutdownHooks.Lock()
+	defer shutdownHooks.Unlock()
+	shutdownHooks.hooks = append(shutdownHooks.hooks, f)
+}
+
+// runShutdownHooks calls the hooks in reverse order and returns their errors.
+func runShutdownHooks(ctx context.Context, logger log.FieldLogger) []error {
+	shutdownHooks.Lock()
+	hooks := append([]func(context.Context) error(nil), shutdownHooks.hooks...)
+	shutdownHooks.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i](ctx); err != nil {
+			logger.WithError(err).Error("shutdown hook failed")
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+var shuttingDown int32
+
+// ShuttingDown returns true once a graceful shutdown has begun.
+func ShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) != 0
+}
+
+// withTimeout returns a context that expires after d, or never if d is 0.
+func withTimeout(d time.Duration) (context.Context, context.CancelFunc) {
+	if d == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), d)
+}
+
+// Serve runs the server, and any other servers in its bindings, until one
+// of them fails or it receives a shutdown signal.
+// On a signal, it marks the service as shutting down, waits for the
+// shutdown delay, stops accepting connections on every listener, waits for
+// in-flight requests to complete, and then runs the shutdown hooks.
+// A second signal during shutdown exits immediately.
+// It returns nil if everything shut down cleanly.
+func Serve(server *http.Server, opts RunOptions) error {
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+	signals := opts.Signals
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, signals...)
+	defer signal.Stop(sigchan)
+
+	bindings, err := serverBindings(server, opts)
+	if err != nil {
+		logger.WithError(err).Error("could not listen")
+		ctx, cancel := withTimeout(opts.ShutdownTimeout)
+		defer cancel()
+		runShutdownHooks(ctx, logger)
+		return ServeError{Err: err}
+	}
+	errc := make(chan error, len(bindings))
+	for _, b := range bindings {
+		go func(b Binding) {
+			if b.Server.TLSConfig != nil {
+				// the certificates come from the TLSConfig
+				errc <- b.Server.ServeTLS(b.Listener, "", "")
+				return
+			}
+			errc <- b.Server.Serve(b.Listener)
+		}(b)
+	}
+	servers := uniqueServers(bindings)
+
+	select {
+	case err := <-errc:
+		// we didn't ask it to stop, so this is always a failure, but we
+		// still stop the other servers and give the hooks a chance to
+		// clean up
+		logger.WithError(err).Error("server stopped")
+		ctx, cancel := withTimeout(opts.ShutdownTimeout)
+		defer cancel()
+		shutdownServers(ctx, servers)
+		runShutdownHooks(ctx, logger)
+		return ServeError{Err: err}
+	case sig := <-sigchan:
+		logger.WithField("signal", sig.String()).Info("shutting down")
+	}
+
+	atomic.StoreInt32(&shuttingDown, 1)
+	go func() {
+		sig := <-sigchan
+		logger.WithField("signal", sig.String()).Error("forced exit during shutdown")
+		os.Exit(ExitShutdownError)
+	}()
+	time.Sleep(opts.ShutdownDelay)
+
+	var errs []error
+	ctx, cancel := withTimeout(opts.ShutdownTimeout)
+	defer cancel()
+	for _, err := range shutdownServers(ctx, servers) {
+		logger.WithError(err).Error("could not drain connections")
+		errs = append(errs, err)
+	}
+	hctx, hcancel := withTimeout(opts.ShutdownTimeout)
+	defer hcancel()
+	errs = append(errs, runShutdownHooks(hctx, logger)...)
+	if len(errs) != 0 {
+		return ShutdownError{Errs: errs}
+	}
+	logger.Info("shutdown complete")
+	return nil
+}
+
+// serverBindings returns the bindings to serve: those in opts, or those
+// that StandardSetup set up for server, or else one for server.Addr.
+func serverBindings(server *http.Server, opts RunOptions) ([]Binding, error) {
+	if len(opts.Bindings) != 0 {
+		return opts.Bindings, nil
+	}
+	if open, ok := setupBindings.Load(server); ok {
+		return open.(func() ([]Binding, error))()
+	}
+	addr := server.Addr
+	if addr == "" {
+		addr = ":http"
+		if server.TLSConfig != nil {
+			addr = ":https"
+		}
+	}
+	l, err := Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return []Binding{{Server: server, Listener: l}}, nil
+}
+
+// uniqueServers returns each server in bindings once.
+func uniqueServers(bindings []Binding) []*http.Server {
+	var servers []*http.Server
+	seen := make(map[*http.Server]bool)
+	for _, b := range bindings {
+		if !seen[b.Server] {
+			seen[b.Server] = true
+			servers = append(servers, b.Server)
+		}
+	}
+	return servers
+}
+
+// shutdownServers shuts down the servers in parallel, and returns their
+// errors.
+func shutdownServers(ctx context.Context, servers []*http.Server) []error {
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		errs  []error
+	)
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *http.Server) {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				mutex.Lock()
+				errs = append(errs, err)
+				mutex.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+	return errs
+}
+
+// Run is Serve followed by os.Exit with the corresponding exit code.
+// It never returns.
+func Run(server *http.Server, opts RunOptions) {
+	os.Exit(ExitCode(Serve(server, opts)))
+}
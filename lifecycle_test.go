@@ -0,0 +1,220 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// resetLifecycle clears the shutdown hooks and state, now and when the
+// test finishes.
+func resetLifecycle(t *testing.T) {
+	reset := func() {
+		shutdownHooks.Lock()
+		shutdownHooks.hooks = nil
+		shutdownHooks.Unlock()
+		atomic.StoreInt32(&shuttingDown, 0)
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func quietLogger() log.FieldLogger {
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	return logger
+}
+
+func TestShutdownHookOrder(t *testing.T) {
+	resetLifecycle(t)
+	var order []int
+	for i := 1; i <= 3; i++ {
+		i := i
+		OnShutdown(func(ctx context.Context) error {
+			order = append(order, i)
+			if i == 2 {
+				return errors.New("hook 2 failed")
+			}
+			return nil
+		})
+	}
+	errs := runShutdownHooks(context.Background(), quietLogger())
+	if want := []int{3, 2, 1}; !reflect.DeepEqual(order, want) {
+		t.Errorf("hooks ran in order %v, want %v", order, want)
+	}
+	if len(errs) != 1 || errs[0].Error() != "hook 2 failed" {
+		t.Errorf("got errors %v", errs)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, ExitOK},
+		{ServeError{Err: errors.New("bind failed")}, ExitServeError},
+		{ShutdownError{Errs: []error{context.DeadlineExceeded}}, ExitShutdownError},
+		{errors.New("anything else"), ExitServeError},
+	}
+	for _, tt := range tests {
+		if got := ExitCode(tt.err); got != tt.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+// serveInBackground runs Serve on a local listener, shutting down on
+// SIGHUP, and returns the server's URL and Serve's result.
+func serveInBackground(t *testing.T, handler http.Handler, timeout time.Duration) (string, <-chan error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(server, RunOptions{
+			Logger:          quietLogger(),
+			ShutdownTimeout: timeout,
+			Signals:         []os.Signal{syscall.SIGHUP},
+			Bindings:        []Binding{{Server: server, Listener: l}},
+		})
+	}()
+	return "http://" + l.Addr().String() + "/", done
+}
+
+// signalSelf sends SIGHUP to the test process once Serve is waiting for it.
+func signalSelf(t *testing.T) {
+	t.Helper()
+	// give Serve time to call signal.Notify
+	time.Sleep(50 * time.Millisecond)
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("can't signal the test process: %v", err)
+	}
+}
+
+func TestServeDrains(t *testing.T) {
+	resetLifecycle(t)
+	hooked := make(chan bool, 1)
+	OnShutdown(func(ctx context.Context) error {
+		hooked <- ShuttingDown()
+		return nil
+	})
+	started := make(chan struct{})
+	url, done := serveInBackground(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("finished"))
+	}), 5*time.Second)
+
+	body := make(chan string, 1)
+	go func() {
+		s, _ := get(url)
+		body <- s
+	}()
+	<-started
+	signalSelf(t)
+
+	// the request in flight completes before Serve returns
+	if s := <-body; s != "finished" {
+		t.Errorf("in-flight request got %q", s)
+	}
+	err := <-done
+	if err != nil || ExitCode(err) != ExitOK {
+		t.Errorf("Serve returned %v", err)
+	}
+	select {
+	case down := <-hooked:
+		if !down {
+			t.Error("ShuttingDown was false during the shutdown hooks")
+		}
+	default:
+		t.Error("the shutdown hook didn't run")
+	}
+}
+
+func TestServeShutdownErrors(t *testing.T) {
+	resetLifecycle(t)
+	OnShutdown(func(ctx context.Context) error {
+		return errors.New("couldn't close")
+	})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	url, done := serveInBackground(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}), 100*time.Millisecond)
+	go get(url)
+	<-started
+	signalSelf(t)
+
+	// draining times out, and the hook fails
+	err := <-done
+	se, ok := err.(ShutdownError)
+	if !ok || len(se.Errs) != 2 || ExitCode(err) != ExitShutdownError {
+		t.Errorf("Serve returned %T %v", err, err)
+	}
+}
+
+func TestServeError(t *testing.T) {
+	resetLifecycle(t)
+	hooked := false
+	OnShutdown(func(ctx context.Context) error {
+		hooked = true
+		return nil
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	server := &http.Server{}
+	err = Serve(server, RunOptions{
+		Logger:   quietLogger(),
+		Bindings: []Binding{{Server: server, Listener: l}},
+	})
+	if _, ok := err.(ServeError); !ok || ExitCode(err) != ExitServeError {
+		t.Errorf("Serve returned %T %v", err, err)
+	}
+	if !hooked {
+		t.Error("the shutdown hooks didn't run after the server failed")
+	}
+}
+
+// get returns the body of a GET request.
+func get(url string) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
@@ -0,0 +1,128 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Binding pairs a server with a listener that it serves on. A server can
+// have several bindings, and several servers can be run together.
+type Binding struct {
+	Server   *http.Server
+	Listener net.Listener
+}
+
+// Listen opens a listener for an address: "unix:/path/to/socket" for a
+// unix domain socket, or "tcp:host:port" or just "host:port" for TCP.
+// A stale unix socket left by a previous run is removed first, but one
+// that something is still listening on is an error.
+func Listen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(addr, "unix:")
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			c, err := net.Dial("unix", path)
+			if err == nil {
+				c.Close()
+				return nil, fmt.Errorf("%s is in use", path)
+			}
+			if isStale(err) {
+				os.Remove(path)
+			}
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", strings.TrimPrefix(addr, "tcp:"))
+}
+
+// setupBindings remembers how to open the listeners for the servers that
+// StandardSetup returned, so that Serve can use them. They aren't opened
+// until then, so that a caller can still call ListenAndServe itself.
+var setupBindings sync.Map // *http.Server -> func() ([]Binding, error)
+
+// addListenConfig adds the config items used by bindingsFromConfig.
+func addListenConfig(cf *Config) {
+	cf.AddStringArray("LISTEN")
+	cf.Describe("LISTEN", "addresses to listen on, like :8080 or unix:/run/svc.sock (defaults to :port)")
+	cf.AddInt("ADMIN_PORT", 0, "port for health, metrics, config, and pprof, instead of the main port (0 to serve them on the main port)")
+	cf.AddFlag("PPROF", true, "serve pprof at /debug/pprof/ on the admin port (only if ADMIN_PORT is set)")
+}
+
+// bindingsFromConfig opens the listeners for the main server and, if it
+// isn't nil, the admin server. Sockets passed by systemd are used first:
+// any named "admin" go to the admin server, and the rest to the main one.
+// Otherwise, the main server listens on LISTEN, or :port if that's empty,
+// and the admin server on :ADMIN_PORT.
+func bindingsFromConfig(cf *Config, server, admin *http.Server) ([]Binding, error) {
+	activated, err := ActivationListeners()
+	if err != nil {
+		return nil, err
+	}
+	var bindings []Binding
+	mains := 0
+	for name, ls := range activated {
+		s := server
+		if name == "admin" {
+			if admin == nil {
+				closeListeners(activated)
+				return nil, fmt.Errorf("LISTEN_FDS: got an admin socket, but ADMIN_PORT isn't set")
+			}
+			s = admin
+		} else {
+			mains += len(ls)
+		}
+		for _, l := range ls {
+			bindings = append(bindings, Binding{Server: s, Listener: l})
+		}
+	}
+	if mains == 0 {
+		addrs := cf.GetStringArray("LISTEN")
+		if len(addrs) == 0 {
+			addrs = []string{server.Addr}
+		}
+		for _, addr := range addrs {
+			l, err := Listen(addr)
+			if err != nil {
+				closeBindings(bindings)
+				return nil, err
+			}
+			bindings = append(bindings, Binding{Server: server, Listener: l})
+		}
+	}
+	if admin != nil && len(activated["admin"]) == 0 {
+		l, err := Listen(admin.Addr)
+		if err != nil {
+			closeBindings(bindings)
+			return nil, err
+		}
+		bindings = append(bindings, Binding{Server: admin, Listener: l})
+	}
+	return bindings, nil
+}
+
+func closeListeners(listeners map[string][]net.Listener) {
+	for _, ls := range listeners {
+		for _, l := range ls {
+			l.Close()
+		}
+	}
+}
+
+func closeBindings(bindings []Binding) {
+	for _, b := range bindings {
+		b.Listener.Close()
+	}
+}
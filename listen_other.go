@@ -0,0 +1,36 @@
+//go:build !unix
+
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// isStale reports whether an error dialing a unix socket means that
+// nothing is listening on it any more. The errors differ between the
+// other platforms, so any failure counts.
+func isStale(err error) bool {
+	return err != nil
+}
+
+// ActivationListeners returns the listeners passed by systemd socket
+// activation, which is only supported on unix. It returns an error if
+// LISTEN_FDS is set.
+func ActivationListeners() (map[string][]net.Listener, error) {
+	if os.Getenv("LISTEN_FDS") != "" {
+		return nil, errors.New("LISTEN_FDS: socket activation is not supported on this platform")
+	}
+	return nil, nil
+}
@@ -0,0 +1,81 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+// listenConfig returns a config with the listen items parsed from args.
+func listenConfig(t *testing.T, args ...string) *Config {
+	t.Helper()
+	cf := NewConfig()
+	addListenConfig(cf)
+	if err := cf.ParseArgsE(args); err != nil {
+		t.Fatal(err)
+	}
+	return cf
+}
+
+// serversOf returns the server of each binding, and closes the listeners.
+func serversOf(bindings []Binding) []*http.Server {
+	servers := make([]*http.Server, len(bindings))
+	for i, b := range bindings {
+		servers[i] = b.Server
+		b.Listener.Close()
+	}
+	return servers
+}
+
+func TestListenTCP(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:0", "tcp:127.0.0.1:0"} {
+		l, err := Listen(addr)
+		if err != nil {
+			t.Errorf("%s: %v", addr, err)
+			continue
+		}
+		if _, ok := l.Addr().(*net.TCPAddr); !ok {
+			t.Errorf("%s: listening on %v", addr, l.Addr())
+		}
+		l.Close()
+	}
+}
+
+func TestBindingsFromConfig(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "")
+	server := &http.Server{Addr: "127.0.0.1:0"}
+	admin := &http.Server{Addr: "127.0.0.1:0"}
+
+	// just the main port
+	bindings, err := bindingsFromConfig(listenConfig(t), server, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serversOf(bindings); len(got) != 1 || got[0] != server {
+		t.Errorf("got %v", got)
+	}
+
+	// several LISTEN addresses, and a separate admin port
+	bindings, err = bindingsFromConfig(listenConfig(t, "--LISTEN=127.0.0.1:0,tcp:127.0.0.1:0"), server, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serversOf(bindings); len(got) != 3 || got[0] != server || got[1] != server || got[2] != admin {
+		t.Errorf("got %v", got)
+	}
+
+	// a bad address closes the listeners already opened
+	if _, err := bindingsFromConfig(listenConfig(t, "--LISTEN=127.0.0.1:0,nowhere:-1"), server, admin); err == nil {
+		t.Error("listened on a bad address")
+	}
+}
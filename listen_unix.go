@@ -0,0 +1,73 @@
+//go:build unix
+
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// isStale reports whether an error dialing a unix socket means that
+// nothing is listening on it any more.
+func isStale(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// listenFdsStart is the first file descriptor passed by systemd. It's
+// only changed by tests.
+var listenFdsStart = 3
+
+// ActivationListeners returns the listeners passed to the process by
+// systemd socket activation (the LISTEN_FDS protocol), keyed by their
+// names from LISTEN_FDNAMES (systemd uses "unknown" if a socket has no
+// FileDescriptorName). It returns nil if there are none. It unsets the
+// environment variables so that child processes don't inherit them.
+func ActivationListeners() (map[string][]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make(map[string][]net.Listener)
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		// FileListener dups the descriptor, so the original can be closed
+		f.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("LISTEN_FDS: fd %d: %s", fd, err)
+		}
+		listeners[name] = append(listeners[name], l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,149 @@
+//go:build unix
+
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "svc.sock")
+
+	// a socket left behind by a listener that has gone is replaced
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	l, err := Listen("unix:" + path)
+	if err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	defer l.Close()
+
+	// a live one isn't
+	if _, err := Listen("unix:" + path); err == nil {
+		t.Error("replaced a socket that is in use")
+	}
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Errorf("the live socket was removed: %v", err)
+	} else {
+		c.Close()
+	}
+
+	// and nor is something that isn't a socket
+	file := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(file, []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen("unix:" + file); err == nil {
+		t.Error("listened over a file")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("the file was removed: %v", err)
+	}
+}
+
+// activate makes it look as though systemd passed the process a TCP
+// socket named name, and returns its address.
+func activate(t *testing.T, name string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	l.Close()
+	saved := listenFdsStart
+	listenFdsStart = fd
+	t.Cleanup(func() { listenFdsStart = saved })
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", name)
+	return l.Addr().String()
+}
+
+func TestActivationListeners(t *testing.T) {
+	addr := activate(t, "web")
+	listeners, err := ActivationListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 || len(listeners["web"]) != 1 || listeners["web"][0].Addr().String() != addr {
+		t.Fatalf("got %v", listeners)
+	}
+	closeListeners(listeners)
+	for _, v := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if os.Getenv(v) != "" {
+			t.Errorf("%s is still set", v)
+		}
+	}
+
+	// another process's sockets are ignored
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if listeners, err := ActivationListeners(); listeners != nil || err != nil {
+		t.Errorf("got %v, %v", listeners, err)
+	}
+}
+
+func TestBindingsFromActivation(t *testing.T) {
+	server := &http.Server{Addr: "127.0.0.1:0"}
+	admin := &http.Server{Addr: "127.0.0.1:0"}
+
+	// an activated admin socket goes to the admin server, and the main
+	// server still listens on its port
+	addr := activate(t, "admin")
+	bindings, err := bindingsFromConfig(listenConfig(t), server, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bindings) != 2 || bindings[0].Server != admin || bindings[0].Listener.Addr().String() != addr || bindings[1].Server != server {
+		t.Errorf("got %v", bindings)
+	}
+	serversOf(bindings)
+
+	// but it's an error if there's no admin server
+	activate(t, "admin")
+	if _, err := bindingsFromConfig(listenConfig(t), server, nil); err == nil {
+		t.Error("accepted an admin socket without ADMIN_PORT")
+	}
+
+	// an activated main socket replaces the main port
+	addr = activate(t, "web")
+	bindings, err = bindingsFromConfig(listenConfig(t), server, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bindings) != 1 || bindings[0].Server != server || bindings[0].Listener.Addr().String() != addr {
+		t.Errorf("got %v", bindings)
+	}
+	serversOf(bindings)
+}
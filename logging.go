@@ -13,14 +13,24 @@ package rest
 import (
 	"bufio"
 	"errors"
+	"io"
 	"net"
 	"net/http"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 // LogWriter proxies http.ResponseWriter and logs.
+// It forwards the optional interfaces (http.Flusher, http.Hijacker,
+// http.Pusher, io.ReaderFrom, and http.CloseNotifier) to the underlying
+// writer, so that streaming responses like server-sent events still work
+// through it. Where the underlying writer doesn't support one, the method
+// does the closest safe thing, as described on each.
+//
+// This means that LogWriter implements all of them whether the underlying
+// writer does or not, so a type assertion can't tell. Handlers that need
+// to know should use http.NewResponseController, whose Flush, Hijack,
+// and the like report http.ErrNotSupported.
 type LogWriter struct {
 	http.ResponseWriter
 	status int
@@ -36,6 +46,75 @@ func (w *LogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	}
 }
 
+// Flush implements http.Flusher for LogWriter. It does nothing if the
+// underlying writer can't flush. Flushing sends the headers, so if no
+// status was written, the response has started with an implicit 200.
+func (w *LogWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// FlushError flushes like Flush, but returns http.ErrNotSupported if the
+// underlying writer can't flush, or its error if it can.
+// http.ResponseController uses it in preference to Flush.
+func (w *LogWriter) FlushError() error {
+	switch f := w.ResponseWriter.(type) {
+	case interface{ FlushError() error }:
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		return f.FlushError()
+	case http.Flusher:
+		w.Flush()
+		return nil
+	}
+	return http.ErrNotSupported
+}
+
+// Push implements http.Pusher for LogWriter. It returns
+// http.ErrNotSupported if the underlying writer can't push.
+func (w *LogWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// ReadFrom implements io.ReaderFrom for LogWriter, so that the server can
+// use sendfile when copying a file to the response. If the underlying
+// writer isn't a ReaderFrom, it copies through Write.
+func (w *LogWriter) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		n, err := rf.ReadFrom(r)
+		w.length += int(n)
+		return n, err
+	}
+	// hide our own ReadFrom from io.Copy so that it doesn't recurse
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
+
+// CloseNotify implements http.CloseNotifier for LogWriter. If the
+// underlying writer isn't a CloseNotifier, the channel never receives;
+// handlers should use the request context instead anyway.
+func (w *LogWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *LogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // WriteHeader proxies http.ResponseWriter.WriteHeader
 func (w *LogWriter) WriteHeader(status int) {
 	w.status = status
@@ -50,21 +129,8 @@ func (w *LogWriter) Write(b []byte) (int, error) {
 }
 
 // LogMW wraps a regular handler and replaces the writer with some logging middleware.
+// It logs every request to logger in the logrus format; use AccessLogMW
+// for other formats and destinations.
 func LogMW(logger log.FieldLogger, handler http.Handler) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		lw := LogWriter{ResponseWriter: w}
-		handler.ServeHTTP(&lw, r)
-		duration := time.Now().Sub(start)
-		logger.WithFields(log.Fields{
-			"host":       r.Host,
-			"remoteAddr": r.RemoteAddr,
-			"method":     r.Method,
-			"uri":        r.RequestURI,
-			"code":       lw.status,
-			"len":        lw.length,
-			"ua":         r.Header.Get("User-Agent"),
-			"took":       duration,
-		}).Info("REQ")
-	}
+	return AccessLogMW(&AccessLog{Logger: logger}, handler)
 }
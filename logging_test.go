@@ -0,0 +1,248 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeWriter implements every optional interface, and records which ones
+// were used.
+type fakeWriter struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+	pushed   string
+	readFrom bool
+	closed   chan bool
+}
+
+func newFakeWriter() *fakeWriter {
+	return &fakeWriter{ResponseRecorder: httptest.NewRecorder(), closed: make(chan bool, 1)}
+}
+
+func (f *fakeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	f.hijacked = true
+	c, _ := net.Pipe()
+	return c, bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c)), nil
+}
+
+func (f *fakeWriter) Push(target string, opts *http.PushOptions) error {
+	f.pushed = target
+	return nil
+}
+
+func (f *fakeWriter) ReadFrom(r io.Reader) (int64, error) {
+	f.readFrom = true
+	return io.Copy(f.ResponseRecorder, r)
+}
+
+func (f *fakeWriter) CloseNotify() <-chan bool {
+	return f.closed
+}
+
+// These are the optional interfaces, as bits of a mask.
+const (
+	hasFlusher = 1 << iota
+	hasHijacker
+	hasPusher
+	hasReaderFrom
+	hasCloseNotifier
+	allInterfaces = 1<<iota - 1
+)
+
+// masks are the combinations of interfaces that the forwarding tests use:
+// none, each one alone, and all of them. Each forwarding method checks for
+// a single interface, so the other combinations would add nothing.
+var masks = []int{0, hasFlusher, hasHijacker, hasPusher, hasReaderFrom, hasCloseNotifier, allInterfaces}
+
+// exposing returns a writer that exposes only the interfaces in mask, which
+// must be one of masks.
+func exposing(f *fakeWriter, mask int) http.ResponseWriter {
+	type rw = http.ResponseWriter
+	switch mask {
+	case 0:
+		return struct{ rw }{f}
+	case hasFlusher:
+		return struct {
+			rw
+			http.Flusher
+		}{f, f}
+	case hasHijacker:
+		return struct {
+			rw
+			http.Hijacker
+		}{f, f}
+	case hasPusher:
+		return struct {
+			rw
+			http.Pusher
+		}{f, f}
+	case hasReaderFrom:
+		return struct {
+			rw
+			io.ReaderFrom
+		}{f, f}
+	case hasCloseNotifier:
+		return struct {
+			rw
+			http.CloseNotifier
+		}{f, f}
+	case allInterfaces:
+		return f
+	}
+	panic("bad mask")
+}
+
+func maskName(mask int) string {
+	var names []string
+	for i, name := range []string{"Flusher", "Hijacker", "Pusher", "ReaderFrom", "CloseNotifier"} {
+		if mask&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "+")
+}
+
+func TestExposing(t *testing.T) {
+	for _, mask := range masks {
+		w := exposing(newFakeWriter(), mask)
+		_, fl := w.(http.Flusher)
+		_, hj := w.(http.Hijacker)
+		_, pu := w.(http.Pusher)
+		_, rf := w.(io.ReaderFrom)
+		_, cn := w.(http.CloseNotifier)
+		got := 0
+		for i, ok := range []bool{fl, hj, pu, rf, cn} {
+			if ok {
+				got |= 1 << i
+			}
+		}
+		if got != mask {
+			t.Errorf("exposing(%s) exposes %s", maskName(mask), maskName(got))
+		}
+	}
+}
+
+func TestLogWriterForwarding(t *testing.T) {
+	for _, mask := range masks {
+		t.Run(maskName(mask), func(t *testing.T) {
+			f := newFakeWriter()
+			lw := &LogWriter{ResponseWriter: exposing(f, mask)}
+
+			err := http.NewResponseController(lw).Flush()
+			if want := mask&hasFlusher != 0; f.Flushed != want || (err == nil) != want {
+				t.Errorf("flushed = %v, err = %v", f.Flushed, err)
+			} else if want && lw.status != http.StatusOK {
+				t.Errorf("status after flush = %d", lw.status)
+			} else if !want && err != http.ErrNotSupported {
+				t.Errorf("flush err = %v", err)
+			}
+			lw.Flush()
+
+			_, _, err = lw.Hijack()
+			if want := mask&hasHijacker != 0; f.hijacked != want || (err == nil) != want {
+				t.Errorf("hijacked = %v, err = %v", f.hijacked, err)
+			}
+
+			err = lw.Push("/style.css", nil)
+			if mask&hasPusher != 0 {
+				if err != nil || f.pushed != "/style.css" {
+					t.Errorf("pushed %q, err = %v", f.pushed, err)
+				}
+			} else if err != http.ErrNotSupported {
+				t.Errorf("push err = %v", err)
+			}
+
+			n, err := lw.ReadFrom(strings.NewReader("hello"))
+			if err != nil || n != 5 || lw.length != 5 || f.Body.String() != "hello" {
+				t.Errorf("ReadFrom wrote %d (length %d, body %q), err = %v", n, lw.length, f.Body, err)
+			}
+			if want := mask&hasReaderFrom != 0; f.readFrom != want {
+				t.Errorf("readFrom = %v", f.readFrom)
+			}
+			if lw.status == 0 && mask&(hasFlusher|hasReaderFrom) != 0 {
+				t.Error("status is still 0 after the response started")
+			}
+
+			n2, err := lw.Write([]byte(" world"))
+			if err != nil || n2 != 6 || lw.length != 11 {
+				t.Errorf("Write wrote %d (length %d), err = %v", n2, lw.length, err)
+			}
+
+			ch := lw.CloseNotify()
+			if ch == nil {
+				t.Fatal("CloseNotify returned nil")
+			}
+			f.closed <- true
+			select {
+			case <-ch:
+				if mask&hasCloseNotifier == 0 {
+					t.Error("got a close notification that wasn't forwarded")
+				}
+			default:
+				if mask&hasCloseNotifier != 0 {
+					t.Error("close notification wasn't forwarded")
+				}
+			}
+
+			if lw.Unwrap() == nil {
+				t.Error("Unwrap returned nil")
+			}
+		})
+	}
+}
+
+func TestThrottleWriterForwarding(t *testing.T) {
+	for _, mask := range masks {
+		t.Run(maskName(mask), func(t *testing.T) {
+			f := newFakeWriter()
+			tw := &ThrottleWriter{ResponseWriter: exposing(f, mask), ctx: context.Background(), bucket: newByteBucket(1 << 20)}
+			var w http.ResponseWriter = tw
+			if _, ok := w.(io.ReaderFrom); ok {
+				t.Error("ThrottleWriter forwards ReadFrom, which bypasses the limit")
+			}
+
+			tw.Flush()
+			if want := mask&hasFlusher != 0; f.Flushed != want {
+				t.Errorf("flushed = %v", f.Flushed)
+			}
+			_, _, err := tw.Hijack()
+			if want := mask&hasHijacker != 0; f.hijacked != want || (err == nil) != want {
+				t.Errorf("hijacked = %v, err = %v", f.hijacked, err)
+			}
+			err = tw.Push("/style.css", nil)
+			if want := mask&hasPusher != 0; (f.pushed != "") != want || (err == nil) != want {
+				t.Errorf("pushed %q, err = %v", f.pushed, err)
+			}
+			f.closed <- true
+			select {
+			case <-tw.CloseNotify():
+				if mask&hasCloseNotifier == 0 {
+					t.Error("got a close notification that wasn't forwarded")
+				}
+			default:
+				if mask&hasCloseNotifier != 0 {
+					t.Error("close notification wasn't forwarded")
+				}
+			}
+		})
+	}
+}
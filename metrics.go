@@ -0,0 +1,156 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry is the prometheus registry served at METRICS_PATH. It includes
+// the Go runtime and process collectors; services can register their own
+// metrics with it.
+var Registry = newRegistry()
+
+func newRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector())
+	reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return reg
+}
+
+// unmatchedRoute labels requests that didn't match any route, so that
+// random URIs can't blow up the number of time series.
+const unmatchedRoute = "unmatched"
+
+// knownMethods are the methods that get their own label; the rest are
+// labelled "other", for the same reason.
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// methodLabel returns the method label for a request.
+func methodLabel(method string) string {
+	if knownMethods[method] {
+		return method
+	}
+	return "other"
+}
+
+// Metrics records prometheus metrics about HTTP requests, labelled by
+// method, status class (2xx, 4xx, ...), and route pattern.
+type Metrics struct {
+	Requests *prometheus.CounterVec
+	Duration *prometheus.HistogramVec
+	Size     *prometheus.HistogramVec
+	InFlight prometheus.Gauge
+	// Panics is labelled by route only, and is incremented by RecoverMW.
+	Panics *prometheus.CounterVec
+}
+
+// NewMetrics creates the request metrics and registers them with reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	labels := []string{"method", "status", "route"}
+	m := &Metrics{
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of HTTP requests handled.",
+		}, labels),
+		Duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests.",
+			Buckets: prometheus.DefBuckets,
+		}, labels),
+		Size: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_response_size_bytes",
+			Help:    "Size of HTTP response bodies.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+		}, labels),
+		InFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of HTTP requests currently being handled.",
+		}),
+		Panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_handler_panics_total",
+			Help: "Number of panics recovered from HTTP handlers.",
+		}, []string{"route"}),
+	}
+	reg.MustRegister(m.Requests, m.Duration, m.Size, m.InFlight, m.Panics)
+	return m
+}
+
+var defaultMetrics struct {
+	once sync.Once
+	m    *Metrics
+}
+
+// DefaultMetrics returns the request metrics registered with Registry,
+// creating them the first time.
+func DefaultMetrics() *Metrics {
+	defaultMetrics.once.Do(func() {
+		defaultMetrics.m = NewMetrics(Registry)
+	})
+	return defaultMetrics.m
+}
+
+// statusClass turns a status code into a label like "2xx".
+func statusClass(code int) string {
+	if code == 0 {
+		// nothing called WriteHeader, so net/http sent a 200
+		code = http.StatusOK
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// MetricsMW wraps a handler and records metrics for every request.
+// It labels requests with the pattern stored by RouteMW, so it must be
+// installed inside RouteMW.
+func (m *Metrics) MetricsMW(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.InFlight.Inc()
+		defer m.InFlight.Dec()
+		start := time.Now()
+		lw := LogWriter{ResponseWriter: w}
+		handler.ServeHTTP(&lw, r)
+		route := RoutePattern(r)
+		if route == "" {
+			route = unmatchedRoute
+		}
+		labels := prometheus.Labels{
+			"method": methodLabel(r.Method),
+			"status": statusClass(lw.status),
+			"route":  route,
+		}
+		m.Requests.With(labels).Inc()
+		m.Duration.With(labels).Observe(time.Since(start).Seconds())
+		m.Size.With(labels).Observe(float64(lw.length))
+	}
+}
+
+// MetricsHandler returns a handler that serves the metrics in gatherer in
+// the prometheus text format.
+func MetricsHandler(gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,76 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsLabels(t *testing.T) {
+	m := NewMetrics(prometheus.NewRegistry())
+	rt := NewRouteTable(nil)
+	rt.Add(AnyMethod, "/count/:first/:last")
+	handler := RouteMW(rt, m.MetricsMW(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("status") {
+		case "404":
+			http.NotFound(w, r)
+		case "500":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			// an implicit 200
+			w.Write([]byte("counted"))
+		}
+	})))
+
+	tests := []struct {
+		method, target         string
+		wantMethod, wantStatus string
+		wantRoute              string
+	}{
+		{"GET", "/count/1/10", "GET", "2xx", "/count/:first/:last"},
+		{"POST", "/count/1/10?status=500", "POST", "5xx", "/count/:first/:last"},
+		{"GET", "/nowhere?status=404", "GET", "4xx", unmatchedRoute},
+		{"BREW", "/count/1/10", "other", "2xx", "/count/:first/:last"},
+		{"PROPFIND", "/x", "other", "2xx", unmatchedRoute},
+	}
+	for _, tt := range tests {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.target, nil))
+		labels := prometheus.Labels{"method": tt.wantMethod, "status": tt.wantStatus, "route": tt.wantRoute}
+		c, err := m.Requests.GetMetricWith(labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := testutil.ToFloat64(c); got != 1 {
+			t.Errorf("%s %s: %v counted %v times", tt.method, tt.target, labels, got)
+		}
+	}
+	// each request has exactly one series, so none of them leaked the
+	// raw method or path into a label
+	if n := testutil.CollectAndCount(m.Requests); n != len(tests) {
+		t.Errorf("got %d series, want %d", n, len(tests))
+	}
+	if got := testutil.ToFloat64(m.InFlight); got != 0 {
+		t.Errorf("in flight = %v", got)
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	for code, want := range map[int]string{0: "2xx", 200: "2xx", 301: "3xx", 404: "4xx", 503: "5xx"} {
+		if got := statusClass(code); got != want {
+			t.Errorf("statusClass(%d) = %s, want %s", code, got, want)
+		}
+	}
+}
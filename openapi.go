@@ -0,0 +1,350 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/kentquirk/boneful"
+)
+
+// OpenAPIVersion is the version of the OpenAPI spec that is generated.
+const OpenAPIVersion = "3.0.3"
+
+// OpenAPI is an OpenAPI 3 document. Only the parts that can be derived
+// from a boneful service are included.
+type OpenAPI struct {
+	OpenAPI string              `json:"openapi"`
+	Info    OpenAPIInfo         `json:"info"`
+	Servers []OpenAPIServer     `json:"servers,omitempty"`
+	Paths   map[string]PathItem `json:"paths"`
+}
+
+// OpenAPIInfo describes the API.
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// OpenAPIServer is a base URL for the API.
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+// PathItem maps lowercase HTTP methods to the operations on a path.
+type PathItem map[string]*Operation
+
+// Operation describes a single route.
+type Operation struct {
+	OperationID string              `json:"operationId,omitempty"`
+	Summary     string              `json:"summary,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// Parameter describes a path, query, or header parameter.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the body of a request.
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes a response.
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a body in one media type.
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Schema is a JSON schema, as used by OpenAPI 3.0.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Example              interface{}        `json:"example,omitempty"`
+}
+
+// boneful parameter kinds, which follow go-restful's.
+const (
+	pathParameterKind = iota
+	queryParameterKind
+	bodyParameterKind
+	headerParameterKind
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	marshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	byteSliceType  = reflect.TypeOf([]byte(nil))
+	durationType   = reflect.TypeOf(time.Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// SchemaOf derives a JSON schema from a sample value by reflection,
+// following the encoding/json rules for struct fields. The sample itself
+// is included as the example.
+func SchemaOf(sample interface{}) *Schema {
+	if sample == nil {
+		return nil
+	}
+	s := schemaOf(reflect.TypeOf(sample), map[reflect.Type]bool{})
+	s.Example = sample
+	return s
+}
+
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case byteSliceType:
+		return &Schema{Type: "string", Format: "byte"}
+	case durationType:
+		return &Schema{Type: "integer", Format: "int64"}
+	case rawMessageType:
+		return &Schema{}
+	}
+	if t.Kind() != reflect.Ptr && t.Implements(marshalerType) {
+		// it could marshal itself as anything
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := schemaOf(t.Elem(), seen)
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			// a recursive type; don't expand it again
+			return &Schema{Type: "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		addProperties(s, t, seen)
+		return s
+	}
+	// interfaces, and anything that can't be described, can hold any value
+	return &Schema{}
+}
+
+// addProperties adds the JSON fields of struct type t to s, flattening
+// embedded structs the way encoding/json does.
+func addProperties(s *Schema, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addProperties(s, ft, seen)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fs := schemaOf(ft, seen)
+		if strings.Contains(tag, ",string") {
+			fs = &Schema{Type: "string"}
+		}
+		s.Properties[name] = fs
+	}
+}
+
+// OpenAPIPath converts a bone route pattern to an OpenAPI path template:
+// /count/:first/:last becomes /count/{first}/{last}, and regex parameters
+// like #id^[0-9]+$ become {id}. It also returns the parameter names.
+func OpenAPIPath(pattern string) (string, []string) {
+	var names []string
+	segs := strings.Split(pattern, "/")
+	for i, seg := range segs {
+		var name string
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			name = seg[1:]
+		case strings.HasPrefix(seg, "#"):
+			name = strings.SplitN(seg[1:], "^", 2)[0]
+		default:
+			continue
+		}
+		names = append(names, name)
+		segs[i] = "{" + name + "}"
+	}
+	return strings.Join(segs, "/"), names
+}
+
+// mediaTypes maps each of types (or JSON if there are none) to schema.
+func mediaTypes(types []string, schema *Schema) map[string]MediaType {
+	if len(types) == 0 {
+		types = []string{"application/json"}
+	}
+	content := make(map[string]MediaType, len(types))
+	for _, t := range types {
+		content[t] = MediaType{Schema: schema}
+	}
+	return content
+}
+
+// operation converts a boneful route to an OpenAPI operation.
+func operation(route boneful.Route, pathParams []string) *Operation {
+	op := &Operation{
+		OperationID: route.Operation,
+		Summary:     route.Doc,
+		Description: route.Notes,
+		Responses:   map[string]Response{},
+	}
+	documented := make(map[string]*boneful.Parameter)
+	for _, p := range route.ParameterDocs {
+		documented[p.Name] = p
+	}
+	for _, name := range pathParams {
+		p := Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}}
+		if d, ok := documented[name]; ok {
+			p.Description = d.Description
+			if d.DataType != "" {
+				p.Schema.Type = d.DataType
+			}
+			delete(documented, name)
+		}
+		op.Parameters = append(op.Parameters, p)
+	}
+	for _, d := range route.ParameterDocs {
+		if _, ok := documented[d.Name]; !ok {
+			continue
+		}
+		in := ""
+		switch d.Kind {
+		case queryParameterKind:
+			in = "query"
+		case headerParameterKind:
+			in = "header"
+		default:
+			// path parameters were handled above; body parameters are
+			// described by the request body
+			continue
+		}
+		typ := d.DataType
+		if typ == "" {
+			typ = "string"
+		}
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        d.Name,
+			In:          in,
+			Description: d.Description,
+			Required:    d.Required,
+			Schema:      &Schema{Type: typ},
+		})
+	}
+	if route.ReadSample != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  mediaTypes(route.Consumes, SchemaOf(route.ReadSample)),
+		}
+	}
+	ok := Response{Description: "OK"}
+	if route.WriteSample != nil {
+		ok.Content = mediaTypes(route.Produces, SchemaOf(route.WriteSample))
+	}
+	op.Responses["200"] = ok
+	return op
+}
+
+// NewOpenAPI converts the routes of a boneful service to an OpenAPI 3
+// document with the given title and version.
+func NewOpenAPI(svc *boneful.Service, title, version string) *OpenAPI {
+	doc := &OpenAPI{
+		OpenAPI: OpenAPIVersion,
+		Info: OpenAPIInfo{
+			Title:       title,
+			Description: strings.TrimSpace(svc.Documentation()),
+			Version:     version,
+		},
+		Paths: map[string]PathItem{},
+	}
+	doc.AddService(svc)
+	return doc
+}
+
+// AddService adds the routes of another boneful service to the document.
+func (doc *OpenAPI) AddService(svc *boneful.Service) {
+	for _, route := range svc.Routes() {
+		p, params := OpenAPIPath(route.Path)
+		if doc.Paths[p] == nil {
+			doc.Paths[p] = PathItem{}
+		}
+		doc.Paths[p][strings.ToLower(route.Method)] = operation(route, params)
+	}
+}
+
+// Write writes the document as indented JSON.
+func (doc *OpenAPI) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// OpenAPIHandler returns a handler that serves doc as JSON.
+func OpenAPIHandler(doc *OpenAPI) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		doc.Write(w)
+	})
+}
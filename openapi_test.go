@@ -0,0 +1,106 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOpenAPIPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		names   []string
+	}{
+		{"/", "/", nil},
+		{"/health/ready", "/health/ready", nil},
+		{"/count/:first/:last", "/count/{first}/{last}", []string{"first", "last"}},
+		{"/account/#id^[0-9]+$/history", "/account/{id}/history", []string{"id"}},
+		{"/files/:name/*", "/files/{name}/*", []string{"name"}},
+	}
+	for _, tt := range tests {
+		path, names := OpenAPIPath(tt.pattern)
+		if path != tt.path || !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("OpenAPIPath(%q) = %q, %v; want %q, %v", tt.pattern, path, names, tt.path, tt.names)
+		}
+	}
+}
+
+type schemaBase struct {
+	ID string `json:"id"`
+}
+
+type schemaNode struct {
+	schemaBase
+	Name     string            `json:"name"`
+	Count    int               `json:"count,omitempty"`
+	Big      int64             `json:"big,string"`
+	Ratio    float64           `json:"ratio"`
+	OK       bool              `json:"ok"`
+	When     time.Time         `json:"when"`
+	Wait     time.Duration     `json:"wait"`
+	Data     []byte            `json:"data"`
+	Raw      json.RawMessage   `json:"raw"`
+	Tags     []string          `json:"tags"`
+	Labels   map[string]string `json:"labels"`
+	Parent   *schemaNode       `json:"parent"`
+	Any      interface{}       `json:"any"`
+	Untagged uint8
+	Skipped  string `json:"-"`
+	hidden   string
+}
+
+func TestSchemaOf(t *testing.T) {
+	if SchemaOf(nil) != nil {
+		t.Error("SchemaOf(nil) isn't nil")
+	}
+	sample := schemaNode{Name: "n"}
+	s := SchemaOf(sample)
+	if s.Type != "object" || !reflect.DeepEqual(s.Example, sample) {
+		t.Fatalf("got %+v", s)
+	}
+	want := map[string]Schema{
+		"id":       {Type: "string"},
+		"name":     {Type: "string"},
+		"count":    {Type: "integer", Format: "int64"},
+		"big":      {Type: "string"},
+		"ratio":    {Type: "number", Format: "double"},
+		"ok":       {Type: "boolean"},
+		"when":     {Type: "string", Format: "date-time"},
+		"wait":     {Type: "integer", Format: "int64"},
+		"data":     {Type: "string", Format: "byte"},
+		"raw":      {},
+		"tags":     {Type: "array", Items: &Schema{Type: "string"}},
+		"labels":   {Type: "object", AdditionalProperties: &Schema{Type: "string"}},
+		"any":      {},
+		"Untagged": {Type: "integer", Format: "int32"},
+	}
+	for name, w := range want {
+		got, ok := s.Properties[name]
+		if !ok {
+			t.Errorf("no property %s", name)
+			continue
+		}
+		if !reflect.DeepEqual(*got, w) {
+			t.Errorf("%s: got %+v, want %+v", name, *got, w)
+		}
+	}
+	// the recursive field isn't expanded again
+	if parent := s.Properties["parent"]; parent == nil || !parent.Nullable || parent.Type != "object" || parent.Properties != nil {
+		t.Errorf("parent: %+v", parent)
+	}
+	if len(s.Properties) != len(want)+1 {
+		t.Errorf("got %d properties, want %d", len(s.Properties), len(want)+1)
+	}
+}
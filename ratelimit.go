@@ -0,0 +1,362 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is a token-bucket rate limit: Rate requests per second on average,
+// with bursts of up to Burst requests. The zero Limit means no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited reports whether the limit doesn't restrict anything.
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// ParseLimit parses a limit like "10/s", "100/m", "5000/h", or "20/30s".
+// The burst defaults to the count; it can be set with a suffix, as in
+// "10/s:50". An empty string or "0" means no limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+	spec, burst := s, ""
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		spec, burst = s[:i], s[i+1:]
+	}
+	parts := strings.SplitN(spec, "/", 2)
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("rate limit %q: expected count/period", s)
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: bad count", s)
+	}
+	period := parts[1]
+	switch period {
+	case "s", "m", "h":
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: bad period", s)
+	}
+	l := Limit{Rate: float64(n) / d.Seconds(), Burst: n}
+	if burst != "" {
+		l.Burst, err = strconv.Atoi(burst)
+		if err != nil || l.Burst <= 0 {
+			return Limit{}, fmt.Errorf("rate limit %q: bad burst", s)
+		}
+	}
+	return l, nil
+}
+
+// LimitResult is the outcome of taking a token from a bucket.
+type LimitResult struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a token is available, if !Allowed.
+	RetryAfter time.Duration
+}
+
+// LimiterStore holds the state of the rate limit buckets. MemoryStore
+// keeps it in process; a shared store lets several instances of a service
+// enforce one limit.
+type LimiterStore interface {
+	Take(key string, limit Limit, now time.Time) (LimitResult, error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// full reports whether the bucket will have refilled by now.
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst)
+}
+
+// MemoryStore is a LimiterStore that keeps buckets in memory.
+type MemoryStore struct {
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+// memorySweepInterval is how often MemoryStore drops buckets that have
+// refilled, so that it doesn't grow with every client ever seen.
+const memorySweepInterval = time.Minute
+
+// Take implements LimiterStore.
+func (m *MemoryStore) Take(key string, limit Limit, now time.Time) (LimitResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	burst := float64(limit.Burst)
+	if now.Sub(m.swept) > memorySweepInterval {
+		for k, b := range m.buckets {
+			if b.full(now) {
+				delete(m.buckets, k)
+			}
+		}
+		m.swept = now
+	}
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		m.buckets[key] = b
+	}
+	b.limit = limit
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	res := LimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((burst - b.tokens) / limit.Rate)
+	return res, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// KeyFunc picks the key that a request is rate limited by.
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP limits requests by the client's IP address.
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// KeyByAPIKey limits requests by the authenticated principal, falling
+// back to the client's IP address. Credentials that haven't been verified
+// are ignored, so that a client can't get a fresh limit by making up keys.
+// It needs AuthMW to be installed outside it to see the principal.
+func KeyByAPIKey(r *http.Request) string {
+	if p := GetPrincipal(r); p != nil {
+		return p.Method + ":" + p.ID
+	}
+	return "ip:" + KeyByIP(r)
+}
+
+// RateLimiter applies a global limit and per-route limits (keyed by route
+// pattern) to each client.
+type RateLimiter struct {
+	Store  LimiterStore
+	Key    KeyFunc
+	Global Limit
+	Routes map[string]Limit
+}
+
+// For returns the limit for the route the request matched (see RouteMW),
+// and the scope it applies to: the route pattern for a per-route limit,
+// or "*" for the global limit, which is shared by all other routes.
+func (rl *RateLimiter) For(r *http.Request) (Limit, string) {
+	pattern := RoutePattern(r)
+	if l, ok := rl.Routes[pattern]; ok {
+		return l, pattern
+	}
+	return rl.Global, "*"
+}
+
+// RateLimitMW wraps a handler and rejects requests over their limit with
+// a 429 JSON error and a Retry-After header. Every limited response gets
+// RateLimit-Limit, RateLimit-Remaining, and RateLimit-Reset headers.
+// It must be installed inside RouteMW for per-route limits to work.
+func RateLimitMW(rl *RateLimiter, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, scope := rl.For(r)
+		if limit.Unlimited() {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		res, err := rl.Store.Take(scope+"|"+rl.Key(r), limit, time.Now())
+		if err != nil {
+			// don't turn a broken store into an outage
+			Logger(r).WithError(err).Error("rate limiter store failed")
+			handler.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		if !res.Allowed {
+			h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			WriteJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// AuthFailureLimiter limits how often each client IP can fail
+// authentication. RateLimitMW runs inside AuthMW so that it can key by
+// principal, which leaves requests with bad credentials unlimited; this
+// covers them.
+type AuthFailureLimiter struct {
+	Store LimiterStore
+	Limit Limit
+
+	mutex sync.Mutex
+	// blocked holds when each client that has used up its limit may try
+	// again
+	blocked map[string]time.Time
+	swept   time.Time
+}
+
+// NewAuthFailureLimiter creates an AuthFailureLimiter with a MemoryStore.
+func NewAuthFailureLimiter(limit Limit) *AuthFailureLimiter {
+	return &AuthFailureLimiter{
+		Store:   NewMemoryStore(),
+		Limit:   limit,
+		blocked: make(map[string]time.Time),
+	}
+}
+
+// retryAfter returns how long the client must wait before its credentials
+// are checked again, or 0 if it needn't.
+func (fl *AuthFailureLimiter) retryAfter(key string, now time.Time) time.Duration {
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+	if now.Sub(fl.swept) > memorySweepInterval {
+		for k, until := range fl.blocked {
+			if !now.Before(until) {
+				delete(fl.blocked, k)
+			}
+		}
+		fl.swept = now
+	}
+	if until, ok := fl.blocked[key]; ok && now.Before(until) {
+		return until.Sub(now)
+	}
+	return 0
+}
+
+// fail counts a failure, blocking the client if it was one too many.
+func (fl *AuthFailureLimiter) fail(key string, now time.Time) error {
+	res, err := fl.Store.Take(key, fl.Limit, now)
+	if err != nil || res.Allowed {
+		return err
+	}
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+	fl.blocked[key] = now.Add(res.RetryAfter)
+	return nil
+}
+
+// AuthFailureLimitMW wraps a handler (normally AuthMW) and counts its 401
+// responses against the client's IP address. Once a client has failed too
+// often, its requests are rejected with a 429 before their credentials
+// are checked, so that guessing can't go faster than the limit.
+func AuthFailureLimitMW(fl *AuthFailureLimiter, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := "authfail|" + KeyByIP(r)
+		if wait := fl.retryAfter(key, time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+			WriteJSONError(w, http.StatusTooManyRequests, "too many failed authentications")
+			return
+		}
+		lw := LogWriter{ResponseWriter: w}
+		handler.ServeHTTP(&lw, r)
+		if lw.status == http.StatusUnauthorized {
+			if err := fl.fail(key, time.Now()); err != nil {
+				Logger(r).WithError(err).Error("rate limiter store failed")
+			}
+		}
+	}
+}
+
+// addRateLimitConfig adds the config items used by RateLimiterFromConfig
+// and the bandwidth throttle.
+func addRateLimitConfig(cf *Config) {
+	cf.AddString("RATE_LIMIT", "", "requests allowed per client, like 10/s or 100/m:200 (count/period:burst; empty for no limit)")
+	cf.AddStringMap("RATE_LIMIT_ROUTES", "", "per-route rate limits, like /count/:first/:last=5/s")
+	cf.AddEnum("RATE_LIMIT_KEY", "ip", "ip", "apikey")
+	cf.Describe("RATE_LIMIT_KEY", "what to rate limit by: the client's IP, or its authenticated principal (falling back to its IP)")
+	cf.AddString("AUTH_FAILURE_LIMIT", "20/m", "failed authentications allowed per client IP, like 20/m (empty for no limit)")
+	cf.AddByteSize("THROTTLE_RATE", "0", "maximum response bytes per second per connection (0 for no limit; large responses may need a longer WRITE_TIMEOUT)")
+}
+
+// RateLimiterFromConfig creates a RateLimiter with a MemoryStore from the
+// config, or returns nil if no limits are set.
+func RateLimiterFromConfig(cf *Config) (*RateLimiter, error) {
+	global, err := ParseLimit(cf.GetString("RATE_LIMIT"))
+	if err != nil {
+		return nil, err
+	}
+	rl := &RateLimiter{
+		Store:  NewMemoryStore(),
+		Key:    KeyByIP,
+		Global: global,
+		Routes: make(map[string]Limit),
+	}
+	if cf.GetEnum("RATE_LIMIT_KEY") == "apikey" {
+		rl.Key = KeyByAPIKey
+	}
+	for route, s := range cf.GetStringMap("RATE_LIMIT_ROUTES") {
+		l, err := ParseLimit(s)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMIT_ROUTES %s: %s", route, err)
+		}
+		rl.Routes[route] = l
+	}
+	if global.Unlimited() && len(rl.Routes) == 0 {
+		return nil, nil
+	}
+	return rl, nil
+}
+
+// AuthFailureLimiterFromConfig creates an AuthFailureLimiter from the
+// config, or returns nil if AUTH_FAILURE_LIMIT is empty.
+func AuthFailureLimiterFromConfig(cf *Config) (*AuthFailureLimiter, error) {
+	limit, err := ParseLimit(cf.GetString("AUTH_FAILURE_LIMIT"))
+	if err != nil {
+		return nil, fmt.Errorf("AUTH_FAILURE_LIMIT: %s", err)
+	}
+	if limit.Unlimited() {
+		return nil, nil
+	}
+	return NewAuthFailureLimiter(limit), nil
+}
@@ -0,0 +1,90 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeyByAPIKeyIgnoresUnverifiedKeys(t *testing.T) {
+	rl := &RateLimiter{
+		Store:  NewMemoryStore(),
+		Key:    KeyByAPIKey,
+		Global: Limit{Rate: 1, Burst: 1},
+	}
+	h := RateLimitMW(rl, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	passed := 0
+	for i := 0; i < 20; i++ {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(APIKeyHeader, fmt.Sprintf("junk-%d", i))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code == http.StatusOK {
+			passed++
+		}
+	}
+	if passed != 1 {
+		t.Errorf("%d of 20 requests with made-up keys passed a limit of 1", passed)
+	}
+}
+
+func TestKeyByAPIKeyUsesPrincipal(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if got := KeyByAPIKey(r); got != "ip:192.0.2.1" {
+		t.Errorf("got %q", got)
+	}
+	p := &Principal{ID: "alice", Method: "apikey"}
+	r = r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+	if got := KeyByAPIKey(r); got != "apikey:alice" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestAuthFailureLimit(t *testing.T) {
+	auth := MultiAuth{NewAPIKeyAuth(map[string]string{"alice": "k-alice"})}
+	policies := AuthPolicies{Default: AuthRequired}
+	fl := NewAuthFailureLimiter(Limit{Rate: 1.0 / 60, Burst: 3})
+	h := RequestIDMW(quietLogger(), AuthFailureLimitMW(fl, AuthMW(auth, policies, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))))
+	try := func(ip, key string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set(APIKeyHeader, key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// the successes don't count
+	for i := 0; i < 5; i++ {
+		if code := try("192.0.2.1", "k-alice"); code != http.StatusOK {
+			t.Fatalf("good key got %d", code)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if code := try("192.0.2.1", fmt.Sprintf("guess-%d", i)); code != http.StatusUnauthorized {
+			t.Errorf("guess %d got %d", i, code)
+		}
+	}
+	// the fourth failure uses up the limit, and then even the right key
+	// isn't checked
+	try("192.0.2.1", "guess-3")
+	if code := try("192.0.2.1", "k-alice"); code != http.StatusTooManyRequests {
+		t.Errorf("good key after too many guesses got %d", code)
+	}
+	// other clients aren't affected
+	if code := try("192.0.2.2", "k-alice"); code != http.StatusOK {
+		t.Errorf("another client got %d", code)
+	}
+}
@@ -0,0 +1,73 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// APIError is the body of an error response. It has the same shape as the
+// errors that ndauapi's reqres.NewAPIError produces, so clients can handle
+// errors from the middleware and from handlers the same way.
+type APIError struct {
+	Msg string `json:"msg"`
+}
+
+// WriteJSONError writes an APIError with the given status.
+func WriteJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIError{Msg: msg})
+}
+
+// RecoverMW wraps a handler and recovers from any panic in it, logging the
+// panic and its stack trace along with the request, counting it in panics
+// (if it isn't nil), and responding with a 500 JSON error if the handler
+// hadn't started its response yet.
+// It should be installed inside LogMW so that the request is still logged.
+func RecoverMW(panics *prometheus.CounterVec, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lw := LogWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// this is how handlers deliberately abort a response
+				panic(p)
+			}
+			value, stack := panicDetails(p)
+			Logger(r).WithFields(log.Fields{
+				"method": r.Method,
+				"uri":    r.RequestURI,
+				"panic":  fmt.Sprint(value),
+				"stack":  string(stack),
+			}).Error("panic in handler")
+			if panics != nil {
+				route := RoutePattern(r)
+				if route == "" {
+					route = unmatchedRoute
+				}
+				panics.WithLabelValues(route).Inc()
+			}
+			if lw.status == 0 && lw.length == 0 {
+				WriteJSONError(&lw, http.StatusInternalServerError, "internal server error")
+			}
+		}()
+		handler.ServeHTTP(&lw, r)
+	}
+}
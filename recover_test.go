@@ -0,0 +1,76 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// recovering wraps handler in RecoverMW, with a quiet logger.
+func recovering(panics *prometheus.CounterVec, handler http.HandlerFunc) http.Handler {
+	return RequestIDMW(quietLogger(), RecoverMW(panics, handler))
+}
+
+func TestRecover(t *testing.T) {
+	m := NewMetrics(prometheus.NewRegistry())
+	h := recovering(m.Panics, func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	var body APIError
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusInternalServerError || body.Msg != "internal server error" {
+		t.Errorf("got %d %+v", w.Code, body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type %q", ct)
+	}
+	if got := testutil.ToFloat64(m.Panics.WithLabelValues(unmatchedRoute)); got != 1 {
+		t.Errorf("counted %v panics", got)
+	}
+}
+
+func TestRecoverAbort(t *testing.T) {
+	h := recovering(nil, func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("recovered %v", p)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	t.Error("ErrAbortHandler was swallowed")
+}
+
+// A handler that streams and then panics must not have an error appended
+// to its stream.
+func TestRecoverAfterFlush(t *testing.T) {
+	h := recovering(nil, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		panic("boom")
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("got %d %q", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,121 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// configLock protects every Config against reads during a Reload.
+// Since a Config is a map, it can't carry its own lock; reloads are rare
+// enough that sharing one doesn't matter.
+var configLock sync.RWMutex
+
+// snapshot returns a copy of the config that can be read without locking.
+func (cf *Config) snapshot() Config {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	snap := make(Config, len(*cf))
+	for name, ag := range *cf {
+		snap[name] = ag
+	}
+	return snap
+}
+
+// OnChange registers a function to be called when Reload changes the value
+// of the named item. The function receives the old and new values with
+// the same types that the typed getters return (an int item gets ints,
+// a duration item gets time.Durations, and so on).
+func (cf *Config) OnChange(name string, f func(old, new interface{})) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	name = clean(name)
+	ag := (*cf)[name]
+	ag.watchers = append(ag.watchers, f)
+	(*cf)[name] = ag
+}
+
+// Reload rereads the config file, environment, and command line, and if
+// they are valid, replaces the current values and calls the OnChange
+// functions for every item whose value changed. If there are errors, the
+// current values are kept and the errors are returned as a ConfigErrors.
+//
+// Reload is safe to call while other goroutines are reading the config
+// through its getters. Structs filled by Bind are a snapshot taken at
+// load time, and aren't changed by Reload, since handlers may be reading
+// them; use the getters or OnChange for values that can be reloaded.
+func (cf *Config) Reload() error {
+	// start over from the defaults, so that removing a setting from the
+	// file or environment reverts it
+	snap := cf.snapshot()
+	next := make(Config, len(snap))
+	for name, ag := range snap {
+		ag.Value, ag.Raw, ag.Source = nil, "", ""
+		next[name] = ag
+	}
+	if errs := next.load(); len(errs) != 0 {
+		return errs
+	}
+
+	type change struct {
+		name          string
+		watchers      []func(old, new interface{})
+		before, after interface{}
+	}
+	var changes []change
+	for _, name := range next.names() {
+		before, after := snap.typedValue(name), next.typedValue(name)
+		if !reflect.DeepEqual(before, after) {
+			changes = append(changes, change{name: name, before: before, after: after})
+		}
+	}
+
+	configLock.Lock()
+	for name, ag := range next {
+		// keep anything registered since the snapshot was taken
+		cur := (*cf)[name]
+		ag.targets, ag.watchers = cur.targets, cur.watchers
+		(*cf)[name] = ag
+	}
+	for i := range changes {
+		changes[i].watchers = (*cf)[changes[i].name].watchers
+	}
+	configLock.Unlock()
+
+	for _, c := range changes {
+		for _, f := range c.watchers {
+			f(c.before, c.after)
+		}
+	}
+	return nil
+}
+
+// WatchReload reloads the config whenever the process receives SIGHUP,
+// logging the result. It can be used alongside WatchSignals.
+func WatchReload(cf *Config, logger log.FieldLogger) {
+	go func() {
+		sigchan := make(chan os.Signal, 1)
+		signal.Notify(sigchan, syscall.SIGHUP)
+		for range sigchan {
+			if err := cf.Reload(); err != nil {
+				logger.WithError(err).Error("config reload failed; keeping current config")
+				continue
+			}
+			logger.Info("config reloaded")
+		}
+	}()
+}
@@ -0,0 +1,114 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	t.Setenv("WORKERS", "2")
+	cf := NewConfig()
+	cf.AddInt("workers", 1)
+	cf.AddDuration("wait", "1s")
+	cf.AddString("label", "x")
+	if err := cf.LoadE(); err != nil {
+		t.Fatal(err)
+	}
+
+	type call struct{ old, new interface{} }
+	var workers, wait, label []call
+	cf.OnChange("workers", func(old, new interface{}) { workers = append(workers, call{old, new}) })
+	cf.OnChange("wait", func(old, new interface{}) { wait = append(wait, call{old, new}) })
+
+	t.Setenv("WORKERS", "5")
+	if err := cf.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(workers) != 1 || workers[0] != (call{2, 5}) || cf.GetInt("workers") != 5 {
+		t.Errorf("changed item: calls %v, value %d", workers, cf.GetInt("workers"))
+	}
+	if len(wait) != 0 {
+		t.Errorf("unchanged item was reported: %v", wait)
+	}
+
+	// a subscription made after the first reload sees the next one
+	cf.OnChange("label", func(old, new interface{}) { label = append(label, call{old, new}) })
+	t.Setenv("LABEL", "y")
+	t.Setenv("WAIT", "2s")
+	if err := cf.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(label) != 1 || label[0] != (call{"x", "y"}) {
+		t.Errorf("new subscription: %v", label)
+	}
+	if len(wait) != 1 || wait[0] != (call{time.Second, 2 * time.Second}) {
+		t.Errorf("duration change: %v", wait)
+	}
+	if len(workers) != 1 {
+		t.Errorf("workers reported again: %v", workers)
+	}
+
+	// removing a setting reverts it to its default
+	t.Setenv("WORKERS", "")
+	if err := cf.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cf.GetInt("workers") != 1 || len(workers) != 2 {
+		t.Errorf("revert: value %d, calls %v", cf.GetInt("workers"), workers)
+	}
+}
+
+func TestReloadKeepsConfigOnError(t *testing.T) {
+	cf := NewConfig()
+	cf.AddInt("workers", 1)
+	called := false
+	cf.OnChange("workers", func(old, new interface{}) { called = true })
+	t.Setenv("WORKERS", "lots")
+	if err := cf.Reload(); err == nil {
+		t.Error("reload accepted a bad value")
+	}
+	if cf.GetInt("workers") != 1 || called {
+		t.Errorf("value %d, called %v", cf.GetInt("workers"), called)
+	}
+}
+
+func TestReloadLeavesBoundStructs(t *testing.T) {
+	var s struct {
+		Workers int `rest:"workers,default=1"`
+	}
+	cf := NewConfig()
+	if err := cf.Bind(&s); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.LoadE(); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WORKERS", "3")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_ = s.Workers
+			_ = cf.GetInt("workers")
+		}
+	}()
+	if err := cf.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if s.Workers != 1 || cf.GetInt("workers") != 3 {
+		t.Errorf("bound %d, config %d", s.Workers, cf.GetInt("workers"))
+	}
+}
@@ -0,0 +1,96 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader is the header used to pass request IDs between services.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the size of request IDs accepted from clients.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+type loggerKey struct{}
+
+// NewRequestID generates a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID returns true if id is safe to log and echo back:
+// not too long, and only printable ASCII.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDMW wraps a handler, accepting the request ID from the
+// X-Request-ID header or generating one if it is missing or invalid.
+// It stores the ID and a logger tagged with it in the request context,
+// and echoes the ID on the response.
+func RequestIDMW(logger log.FieldLogger, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = context.WithValue(ctx, loggerKey{}, logger.WithField("requestID", id))
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// RequestIDFromContext returns the request ID stored in ctx, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID returns the ID of the request, or "" if RequestIDMW isn't installed.
+func RequestID(r *http.Request) string {
+	return RequestIDFromContext(r.Context())
+}
+
+// Logger returns a logger tagged with the request's ID. If RequestIDMW
+// isn't installed, it returns an entry for the standard logger.
+func Logger(r *http.Request) *log.Entry {
+	if entry, ok := r.Context().Value(loggerKey{}).(*log.Entry); ok {
+		return entry
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// PropagateRequestID copies the request ID from ctx (usually the context
+// of the incoming request) to an outbound request, so that the downstream
+// service logs the same ID.
+func PropagateRequestID(ctx context.Context, out *http.Request) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		out.Header.Set(RequestIDHeader, id)
+	}
+}
@@ -0,0 +1,85 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDMW(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		kept   bool
+	}{
+		{"missing", "", false},
+		{"valid", "abc-123", true},
+		{"longest", strings.Repeat("a", maxRequestIDLength), true},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"space", "abc 123", false},
+		{"control", "abc\x1b[31m", false},
+		{"non-ascii", "abcé", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen, logged string
+			handler := RequestIDMW(quietLogger(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestID(r)
+				logged, _ = Logger(r).Data["requestID"].(string)
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set(RequestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			echoed := w.Header().Get(RequestIDHeader)
+			if echoed == "" || echoed != seen || logged != seen {
+				t.Errorf("echoed %q, handler saw %q, logger has %q", echoed, seen, logged)
+			}
+			if kept := seen == tt.header; kept != tt.kept {
+				t.Errorf("header %q became %q", tt.header, seen)
+			}
+			if !tt.kept && !validRequestID(seen) {
+				t.Errorf("generated an invalid ID %q", seen)
+			}
+		})
+	}
+}
+
+func TestNewRequestIDIsUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := NewRequestID()
+		if seen[id] || len(id) != 32 {
+			t.Fatalf("got %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestPropagateRequestID(t *testing.T) {
+	out := httptest.NewRequest("GET", "http://downstream/", nil)
+	PropagateRequestID(context.Background(), out)
+	if got := out.Header.Get(RequestIDHeader); got != "" {
+		t.Errorf("propagated %q without an ID", got)
+	}
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "abc-123")
+	PropagateRequestID(ctx, out)
+	if got := out.Header.Get(RequestIDHeader); got != "abc-123" {
+		t.Errorf("propagated %q", got)
+	}
+}
@@ -0,0 +1,87 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// checkFile checks the contents of a file, or that it doesn't exist if
+// want is "".
+func checkFile(t *testing.T, path, want string) {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if want == "" {
+		if !os.IsNotExist(err) {
+			t.Errorf("%s exists: %q, %v", filepath.Base(path), b, err)
+		}
+		return
+	}
+	if err != nil || string(b) != want {
+		t.Errorf("%s = %q, %v; want %q", filepath.Base(path), b, err, want)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := ioutil.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	// the existing contents count towards the size, and writes aren't
+	// split between files
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "fivefivefive\n", "six\n"} {
+		if n, err := rf.Write([]byte(line)); n != len(line) || err != nil {
+			t.Fatalf("wrote %d, %v", n, err)
+		}
+	}
+	checkFile(t, path, "six\n")
+	checkFile(t, path+".1", "fivefivefive\n")
+	checkFile(t, path+".2", "four\n")
+	checkFile(t, path+".3", "")
+}
+
+func TestRotatingFileWithoutBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := OpenRotatingFile(path, 8, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		rf.Write([]byte(line))
+	}
+	checkFile(t, path, "three\n")
+	checkFile(t, path+".1", "")
+}
+
+func TestRotatingFileUnlimited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := OpenRotatingFile(path, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	for i := 0; i < 100; i++ {
+		rf.Write([]byte("line\n"))
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 500 {
+		t.Errorf("got %v, %v", fi, err)
+	}
+	checkFile(t, path+".1", "")
+}
@@ -0,0 +1,146 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/kentquirk/boneful"
+)
+
+// AnyMethod matches every method in a RouteTable.
+const AnyMethod = "*"
+
+// routeKey is the context key for the matched route pattern.
+type routeKey struct{}
+
+// RouteTable maps request paths back to the route patterns (like
+// /count/:first/:last) that they matched, so that metrics, logs, and
+// per-route settings can refer to a route without using the raw URI.
+type RouteTable struct {
+	mutex  sync.RWMutex
+	routes map[string][][]string
+}
+
+// NewRouteTable creates a RouteTable containing every route in svc.
+func NewRouteTable(svc *boneful.Service) *RouteTable {
+	rt := &RouteTable{routes: make(map[string][][]string)}
+	if svc != nil {
+		rt.AddService(svc)
+	}
+	return rt
+}
+
+// AddService adds every route in svc to the table.
+func (rt *RouteTable) AddService(svc *boneful.Service) {
+	for method, routes := range svc.Mux().Routes {
+		for _, r := range routes {
+			rt.Add(method, r.Path)
+		}
+	}
+}
+
+// Add adds a route pattern for a method (or AnyMethod). Segments starting
+// with : or # match any single segment, and a trailing * matches the rest
+// of the path.
+func (rt *RouteTable) Add(method, pattern string) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	rt.routes[method] = append(rt.routes[method], splitPath(pattern))
+}
+
+// Has returns true if pattern is exactly one of the patterns in the table,
+// for any method, in the form that Match returns.
+func (rt *RouteTable) Has(pattern string) bool {
+	rt.mutex.RLock()
+	defer rt.mutex.RUnlock()
+	for _, patterns := range rt.routes {
+		for _, p := range patterns {
+			if pattern == "/"+strings.Join(p, "/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func splitPath(p string) []string {
+	return strings.Split(strings.Trim(p, "/"), "/")
+}
+
+// matchSegments returns true if path matches pattern.
+func matchSegments(pattern, path []string) bool {
+	for i, seg := range pattern {
+		if seg == "*" && i == len(pattern)-1 {
+			return true
+		}
+		if i >= len(path) {
+			return false
+		}
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "#") {
+			continue
+		}
+		if seg != path[i] {
+			return false
+		}
+	}
+	return len(pattern) == len(path)
+}
+
+// Match returns the pattern that matches the method and path, or "" if
+// there isn't one. Literal segments are preferred over parameters, so
+// /count/all matches /count/all rather than /count/:n.
+func (rt *RouteTable) Match(method, path string) string {
+	rt.mutex.RLock()
+	defer rt.mutex.RUnlock()
+	segs := splitPath(path)
+	var best []string
+	bestScore := -1
+	for _, m := range []string{method, AnyMethod} {
+		for _, pattern := range rt.routes[m] {
+			if !matchSegments(pattern, segs) {
+				continue
+			}
+			score := 0
+			for _, seg := range pattern {
+				if !strings.HasPrefix(seg, ":") && !strings.HasPrefix(seg, "#") && seg != "*" {
+					score++
+				}
+			}
+			if score > bestScore {
+				best, bestScore = pattern, score
+			}
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return "/" + strings.Join(best, "/")
+}
+
+// RouteMW wraps a handler and stores the route pattern that each request
+// matches in its context, where RoutePattern can find it.
+func RouteMW(rt *RouteTable, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pattern := rt.Match(r.Method, r.URL.Path)
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeKey{}, pattern)))
+	}
+}
+
+// RoutePattern returns the route pattern that the request matched, or ""
+// if it didn't match one (or RouteMW isn't installed).
+func RoutePattern(r *http.Request) string {
+	pattern, _ := r.Context().Value(routeKey{}).(string)
+	return pattern
+}
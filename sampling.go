@@ -0,0 +1,189 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SamplePolicy decides which requests are written to the access log, and
+// at what level. Server errors (status 500 and up) are always logged, at
+// Error, and so are requests that take at least SlowThreshold, at Warn.
+// The rest are sampled, and logged at Info.
+//
+// Sampling keeps 1 in N requests on average, where N is the sample rate:
+// 100 divided by the percentage, so 70% is a rate of about 1.43. The rate
+// is recorded on each entry so that counts can be weighted back up.
+//
+// The zero value logs only errors.
+type SamplePolicy struct {
+	// SlowThreshold is how long a request must take to always be logged;
+	// 0 means none are treated as slow.
+	SlowThreshold time.Duration
+	// Percent is the percentage of other requests that are logged.
+	Percent float64
+	// Routes overrides Percent and Dynamic for some route patterns.
+	Routes map[string]float64
+	// Dynamic, if set, chooses the sample rate for requests that aren't in
+	// Routes by their route pattern and status, instead of Percent.
+	Dynamic *DynamicSampler
+}
+
+// rateForPercent converts a percentage to a sample rate, or 0 if nothing
+// is to be kept.
+func rateForPercent(percent float64) float64 {
+	if percent <= 0 {
+		return 0
+	}
+	if percent >= 100 {
+		return 1
+	}
+	return 100 / percent
+}
+
+// keep returns true with a probability of 1/rate.
+func keep(rate float64) bool {
+	return rate == 1 || (rate > 1 && rand.Float64()*rate < 1)
+}
+
+// decide returns whether a request is logged, the level to log it at, and
+// the rate it was sampled at.
+func (sp *SamplePolicy) decide(r *http.Request, status int, took time.Duration) (bool, log.Level, float64) {
+	if status >= http.StatusInternalServerError {
+		return true, log.ErrorLevel, 1
+	}
+	if sp.SlowThreshold > 0 && took >= sp.SlowThreshold {
+		return true, log.WarnLevel, 1
+	}
+	route := RoutePattern(r)
+	var rate float64
+	if percent, ok := sp.Routes[route]; ok {
+		rate = rateForPercent(percent)
+	} else if sp.Dynamic != nil {
+		rate = float64(sp.Dynamic.Rate(route + " " + strconv.Itoa(status)))
+	} else {
+		rate = rateForPercent(sp.Percent)
+	}
+	return keep(rate), log.InfoLevel, rate
+}
+
+// DynamicSampler picks a sample rate for each key (such as a route and
+// status) so that, on average, 1 in GoalRate requests is kept, while
+// frequent keys are sampled more heavily than rare ones. Rates are
+// recomputed from the counts seen in each interval; keys that weren't
+// seen in the last interval are always kept.
+//
+// This is the average sample rate algorithm from Honeycomb's dynsampler:
+// each key's share of the kept requests is proportional to the log of its
+// count.
+type DynamicSampler struct {
+	GoalRate int
+	Interval time.Duration
+
+	mutex  sync.Mutex
+	counts map[string]int
+	rates  map[string]int
+	reset  time.Time
+}
+
+// NewDynamicSampler creates a DynamicSampler.
+func NewDynamicSampler(goalRate int, interval time.Duration) *DynamicSampler {
+	return &DynamicSampler{
+		GoalRate: goalRate,
+		Interval: interval,
+		counts:   make(map[string]int),
+		rates:    make(map[string]int),
+		reset:    time.Now(),
+	}
+}
+
+// update computes the rates from the counts. The caller must hold the mutex.
+func (ds *DynamicSampler) update() {
+	total, logSum := 0, 0.0
+	for _, count := range ds.counts {
+		total += count
+		logSum += math.Log10(float64(count))
+	}
+	rates := make(map[string]int, len(ds.counts))
+	if logSum > 0 && ds.GoalRate > 1 {
+		goalRatio := float64(total) / float64(ds.GoalRate) / logSum
+		for key, count := range ds.counts {
+			goal := math.Max(1, math.Log10(float64(count))*goalRatio)
+			rates[key] = int(math.Max(1, math.Round(float64(count)/goal)))
+		}
+	}
+	ds.rates = rates
+	ds.counts = make(map[string]int)
+}
+
+// Rate counts a request with the given key, and returns the rate to
+// sample it at.
+func (ds *DynamicSampler) Rate(key string) int {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	if now := time.Now(); now.Sub(ds.reset) >= ds.Interval {
+		ds.update()
+		ds.reset = now
+	}
+	ds.counts[key]++
+	if rate, ok := ds.rates[key]; ok {
+		return rate
+	}
+	return 1
+}
+
+// addSamplingConfig adds the config items used by SamplePolicyFromConfig.
+func addSamplingConfig(cf *Config) {
+	cf.AddDuration("SLOW_REQUEST_THRESHOLD", "0s", "requests that take at least this long are always logged, at warn (0 to disable)")
+	cf.AddFloat("ACCESS_LOG_SAMPLE_PERCENT", 100, "percentage of requests without server errors to log (0 to log only errors and slow requests)")
+	cf.AddStringMap("ACCESS_LOG_SAMPLE_ROUTES", "", "per-route sample percentages, like /count/:first/:last=1")
+	cf.AddInt("ACCESS_LOG_DYNAMIC_SAMPLE_RATE", 0, "sample requests by route and status, logging 1 in this many on average, instead of ACCESS_LOG_SAMPLE_PERCENT (0 to disable)")
+	cf.AddDuration("ACCESS_LOG_DYNAMIC_SAMPLE_INTERVAL", "30s", "how often dynamic sample rates are recomputed")
+}
+
+// SamplePolicyFromConfig creates a SamplePolicy from the config, or returns
+// nil if every request is to be logged at Info.
+func SamplePolicyFromConfig(cf *Config) (*SamplePolicy, error) {
+	sp := &SamplePolicy{
+		SlowThreshold: cf.GetDuration("SLOW_REQUEST_THRESHOLD"),
+		Percent:       cf.GetFloat("ACCESS_LOG_SAMPLE_PERCENT"),
+		Routes:        make(map[string]float64),
+	}
+	if sp.Percent < 0 || sp.Percent > 100 {
+		return nil, fmt.Errorf("ACCESS_LOG_SAMPLE_PERCENT: %v is not a percentage", sp.Percent)
+	}
+	for route, s := range cf.GetStringMap("ACCESS_LOG_SAMPLE_ROUTES") {
+		percent, err := strconv.ParseFloat(s, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("ACCESS_LOG_SAMPLE_ROUTES %s: %q is not a percentage", route, s)
+		}
+		sp.Routes[route] = percent
+	}
+	if goal := cf.GetInt("ACCESS_LOG_DYNAMIC_SAMPLE_RATE"); goal > 0 {
+		interval := cf.GetDuration("ACCESS_LOG_DYNAMIC_SAMPLE_INTERVAL")
+		if interval <= 0 {
+			return nil, fmt.Errorf("ACCESS_LOG_DYNAMIC_SAMPLE_INTERVAL: %v is not positive", interval)
+		}
+		sp.Dynamic = NewDynamicSampler(goal, interval)
+	}
+	if sp.SlowThreshold == 0 && sp.Percent == 100 && len(sp.Routes) == 0 && sp.Dynamic == nil {
+		return nil, nil
+	}
+	return sp, nil
+}
@@ -0,0 +1,116 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSamplePolicyPercentages(t *testing.T) {
+	const n = 20000
+	for _, percent := range []float64{0, 10, 60, 70, 90, 100} {
+		sp := &SamplePolicy{Percent: percent}
+		r := httptest.NewRequest("GET", "/", nil)
+		kept, weighted := 0, 0.0
+		for i := 0; i < n; i++ {
+			ok, level, rate := sp.decide(r, http.StatusOK, 0)
+			if ok {
+				kept++
+				weighted += rate
+				if level != log.InfoLevel {
+					t.Errorf("logged at %s", level)
+				}
+			}
+		}
+		got := 100 * float64(kept) / n
+		if math.Abs(got-percent) > 2 {
+			t.Errorf("%v%% kept %.1f%%", percent, got)
+		}
+		// weighting the kept requests by their rate recovers the total
+		if percent > 0 && math.Abs(weighted-n)/n > 0.1 {
+			t.Errorf("%v%%: weighted count is %.0f, want about %d", percent, weighted, n)
+		}
+	}
+}
+
+func TestSamplePolicyErrorsAndSlowRequests(t *testing.T) {
+	sp := &SamplePolicy{SlowThreshold: time.Second}
+	r := httptest.NewRequest("GET", "/", nil)
+	if ok, _, _ := sp.decide(r, http.StatusOK, 0); ok {
+		t.Error("kept a fast success with 0%")
+	}
+	if ok, level, rate := sp.decide(r, http.StatusBadGateway, 0); !ok || level != log.ErrorLevel || rate != 1 {
+		t.Errorf("server error: %v %s %v", ok, level, rate)
+	}
+	if ok, level, rate := sp.decide(r, http.StatusOK, 2*time.Second); !ok || level != log.WarnLevel || rate != 1 {
+		t.Errorf("slow request: %v %s %v", ok, level, rate)
+	}
+}
+
+func TestDynamicSampler(t *testing.T) {
+	ds := NewDynamicSampler(10, time.Hour)
+	for i := 0; i < 1000; i++ {
+		ds.Rate("/busy 200")
+	}
+	for i := 0; i < 10; i++ {
+		ds.Rate("/quiet 404")
+	}
+	// start the next interval
+	ds.reset = time.Now().Add(-2 * time.Hour)
+	busy, quiet, unseen := ds.Rate("/busy 200"), ds.Rate("/quiet 404"), ds.Rate("/new 200")
+	if !(busy > quiet && quiet >= 1 && unseen == 1) {
+		t.Errorf("got rates %d, %d, %d", busy, quiet, unseen)
+	}
+	// on average, about 1 in 10 of the last interval's requests are kept
+	kept := 1000/float64(busy) + 10/float64(quiet)
+	if kept < 1010/10/2 || kept > 1010/10*2 {
+		t.Errorf("would keep %.0f of 1010", kept)
+	}
+}
+
+func TestSamplePolicyFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		dynamic bool
+		ok      bool
+	}{
+		{"defaults", nil, false, true},
+		{"dynamic", []string{"--ACCESS_LOG_DYNAMIC_SAMPLE_RATE=10"}, true, true},
+		{"zero interval", []string{"--ACCESS_LOG_DYNAMIC_SAMPLE_RATE=10", "--ACCESS_LOG_DYNAMIC_SAMPLE_INTERVAL=0s"}, false, false},
+		{"negative interval", []string{"--ACCESS_LOG_DYNAMIC_SAMPLE_RATE=10", "--ACCESS_LOG_DYNAMIC_SAMPLE_INTERVAL=-1m"}, false, false},
+		{"interval without a rate", []string{"--ACCESS_LOG_DYNAMIC_SAMPLE_INTERVAL=0s"}, false, true},
+		{"bad percent", []string{"--ACCESS_LOG_SAMPLE_PERCENT=101"}, false, false},
+		{"bad route", []string{"--ACCESS_LOG_SAMPLE_ROUTES=/a=lots"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf := NewConfig()
+			addSamplingConfig(cf)
+			if err := cf.ParseArgsE(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			sp, err := SamplePolicyFromConfig(cf)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got err %v", err)
+			}
+			if got := sp != nil && sp.Dynamic != nil; got != tt.dynamic {
+				t.Errorf("dynamic = %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Secret holds a sensitive config value, like an API key. It won't print
+// its value with any fmt verb or marshal it to JSON, so that it can't leak
+// into logs by accident; call Reveal to get at the value.
+type Secret struct {
+	value string
+}
+
+// NewSecret wraps a string as a Secret.
+func NewSecret(s string) Secret {
+	return Secret{value: s}
+}
+
+// Reveal returns the secret value.
+func (s Secret) Reveal() string {
+	return s.value
+}
+
+// IsEmpty returns true if the secret has no value.
+func (s Secret) IsEmpty() bool {
+	return s.value == ""
+}
+
+// String implements fmt.Stringer without revealing the value.
+func (s Secret) String() string {
+	return redacted
+}
+
+// GoString implements fmt.GoStringer so that %#v is redacted too.
+func (s Secret) GoString() string {
+	return redacted
+}
+
+// Format implements fmt.Formatter so that every verb is redacted.
+func (s Secret) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, redacted)
+}
+
+// MarshalJSON implements json.Marshaler without revealing the value.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
+
+// AddSecret adds a config element that is a secret string with no default.
+// Its value is redacted in logs, dumps, and help output. Besides the usual
+// sources, it can be read from a file named by the NAME_FILE environment
+// variable (the way Docker and Kubernetes mount secrets); NAME itself takes
+// priority if both are set.
+// An optional description is shown in the --help output.
+func (cf *Config) AddSecret(name string, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "secret", Default: Secret{}, Help: joinHelp(help), Secret: true}
+}
+
+// GetSecret retrieves a secret from the config, or an empty Secret if not found.
+func (cf *Config) GetSecret(name string) Secret {
+	v, ok := cf.Get(name)
+	if !ok {
+		return Secret{}
+	}
+	return v.(Secret)
+}
+
+// readSecretFile returns the contents of the file named by the NAME_FILE
+// environment variable for a secret item, if there is one.
+func readSecretFile(name string) (string, bool, error) {
+	path := os.Getenv(clean(name) + "_FILE")
+	if path == "" {
+		return "", false, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", true, err
+	}
+	// mounted secrets usually end with a newline that isn't part of the value
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
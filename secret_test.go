@@ -0,0 +1,68 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretNotLeaked(t *testing.T) {
+	cf := NewConfig()
+	cf.AddSecret("token")
+	cf.AddString("marked", "")
+	cf.MarkSecret("marked")
+	if err := cf.ParseArgsE([]string{"--token=hunter2", "--marked=swordfish"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := cf.GetSecret("token").Reveal(); got != "hunter2" {
+		t.Errorf("Reveal() = %q", got)
+	}
+	dump := &bytes.Buffer{}
+	if err := cf.Dump(dump, "json"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{fmt.Sprintf("%v", *cf), fmt.Sprintf("%#v", *cf), dump.String()} {
+		if strings.Contains(s, "hunter2") {
+			t.Errorf("secret leaked: %s", s)
+		}
+	}
+	if strings.Contains(dump.String(), "swordfish") {
+		t.Errorf("marked secret leaked: %s", dump.String())
+	}
+}
+
+func TestSecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TOKEN_FILE", path)
+	cf := NewConfig()
+	cf.AddSecret("token")
+	if err := cf.ParseEnvE(); err != nil {
+		t.Fatal(err)
+	}
+	if got := cf.GetSecret("token").Reveal(); got != "from-file" {
+		t.Errorf("Reveal() = %q", got)
+	}
+	t.Setenv("TOKEN", "from-env")
+	if err := cf.ParseEnvE(); err != nil {
+		t.Fatal(err)
+	}
+	if got := cf.GetSecret("token").Reveal(); got != "from-env" {
+		t.Errorf("Reveal() = %q, want the env to win over the file", got)
+	}
+}
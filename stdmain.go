@@ -11,10 +11,16 @@ package rest
 
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/kentquirk/boneful"
@@ -27,6 +33,7 @@ import (
 // specific signals and can call functions on those signals.
 // In the case of SIGTERM, if the function returns, os.Exit is
 // called with a normal exit code of 0.
+// Services that want in-flight requests to finish should use Run instead.
 func WatchSignals(fhup, fint, fterm func()) {
 	go func() {
 		sigchan := make(chan os.Signal, 1)
@@ -89,12 +96,15 @@ func FatalFunc(builder Builder, reason string) func() {
 // [ ] circleci sample for easy deploy on merge/tag
 // [ ] set up AWS ALB routing and AWS ECS for zero-downtime deploys
 // [ ] wrapper for AWS Dynamo for easy data storage
-// [ ] figure out a way to generate unified docs through a /docs endpoint that knows about the rest
-// [ ] auth token middleware for APIs
-// [ ] request throttling to limit bandwidth
-// [ ] contexts for cancellation
+// [x] figure out a way to generate unified docs through a /docs endpoint that knows about the rest
+// [x] auth token middleware for APIs
+// [x] request throttling to limit bandwidth
+// [x] contexts for cancellation
 
-// Builder is the interface to which all service builders must conform
+// Builder is the interface to which all service builders must conform.
+// A Builder can also implement HealthChecker to add readiness checks,
+// AuthPolicyProvider to set the auth policies of its routes, and
+// MiddlewareProvider to add middleware.
 type Builder interface {
 	Build(logger *log.Entry, path string) *boneful.Service
 	GetLogger() *log.Entry
@@ -104,22 +114,198 @@ type Builder interface {
 // that are used by the standard server.
 func DefaultConfig() *Config {
 	cf := NewConfig()
-	cf.AddString("docs", "")
+	cf.AddString("config", "", "YAML, JSON, or TOML file to read settings from")
+	cf.AddString("docs", "", "write API docs to this file (- for stdout) and exit")
+	cf.AddString("openapi", "", "write the OpenAPI spec to this file (- for stdout) and exit")
+	cf.AddString("OPENAPI_TITLE", "", "title of the OpenAPI spec (defaults to the root path)")
+	cf.AddString("OPENAPI_VERSION", "1.0.0", "API version in the OpenAPI spec")
 	cf.AddStringArray("CORS_ORIGINS", "*")
+	cf.Describe("CORS_ORIGINS", "origins allowed to make cross-site requests")
 	cf.AddStringArray("CORS_METHODS", "GET", "POST", "PUT", "DELETE")
-	cf.AddFlag("CORS_DEBUG", false)
-	cf.AddInt("port", 8080)
-	cf.AddString("rootpath", "/")
-	cf.AddDuration("READ_TIMEOUT", "5s")
-	cf.AddDuration("WRITE_TIMEOUT", "5s")
-	cf.AddString("HONEYCOMB_DATASET", "ndev_backend")
-	cf.AddString("HONEYCOMB_KEY", "")
+	cf.Describe("CORS_METHODS", "methods allowed in cross-site requests")
+	cf.AddFlag("CORS_DEBUG", false, "log CORS decisions")
+	cf.AddInt("port", 8080, "port to listen on")
+	cf.AddString("rootpath", "/", "path prefix for all routes")
+	cf.AddDuration("READ_TIMEOUT", "5s", "maximum time to read a request")
+	cf.AddDuration("WRITE_TIMEOUT", "5s", "maximum time to write a response")
+	cf.AddString("HONEYCOMB_DATASET", "ndev_backend", "honeycomb dataset for logs")
+	cf.AddSecret("HONEYCOMB_KEY", "honeycomb API key")
+	cf.AddFlag("CONFIG_ENDPOINT", false, "serve the effective config at <rootpath>/config")
+	cf.AddEnum("LOG_LEVEL", "info", "trace", "debug", "info", "warn", "error")
+	cf.Describe("LOG_LEVEL", "minimum level of log messages")
+	cf.AddFlag("RELOAD_ON_HUP", true, "reload the config when the process receives SIGHUP")
+	cf.AddDuration("SHUTDOWN_DELAY", "0s", "time to keep serving after a shutdown signal while load balancers notice")
+	cf.AddString("METRICS_PATH", "/metrics", "path to serve prometheus metrics at (empty to disable)")
+	cf.AddDuration("HEALTH_TIMEOUT", "2s", "maximum time for readiness checks to run")
+	cf.AddDuration("SHUTDOWN_TIMEOUT", "10s", "maximum time to drain requests, and to run shutdown hooks, on shutdown")
+	addAuthConfig(cf)
+	addRateLimitConfig(cf)
+	addDeadlineConfig(cf)
+	addTLSConfig(cf)
+	addListenConfig(cf)
+	addAccessLogConfig(cf)
+	cf.AddStringArray("DISABLE_MIDDLEWARE")
+	cf.Describe("DISABLE_MIDDLEWARE", "standard middleware to leave out, like cors or throttle (auth and route can only be left out if nothing needs them)")
+	cf.AddStringArray("DOCS_SERVICES")
+	cf.Describe("DOCS_SERVICES", "docs URLs of sibling services to merge into <rootpath>/docs")
+	cf.AddDuration("DOCS_TIMEOUT", "2s", "maximum time to fetch the docs of sibling services")
+	cf.AddDuration("DOCS_CACHE_TTL", "1m", "how long to cache the docs of sibling services")
 	return cf
 }
 
+// routeDependents are the standard middleware that look up per-route
+// settings, and so need the route middleware outside them.
+var routeDependents = []string{MiddlewareAuth, MiddlewareRateLimit, MiddlewareDeadline}
+
+// disableMiddleware removes the named middleware from the chain. It
+// refuses to remove auth while any route needs authentication, or route
+// while anything that looks up per-route settings is left, since either
+// would quietly open up routes that are meant to be protected.
+func disableMiddleware(chain *Chain, names []string, policies AuthPolicies) error {
+	for _, name := range names {
+		if !chain.Remove(name) {
+			return fmt.Errorf("no middleware named %s", name)
+		}
+		if name == MiddlewareAuth {
+			needed := policies.Default != AuthNone
+			for _, p := range policies.Routes {
+				needed = needed || p != AuthNone
+			}
+			if needed {
+				return errors.New("auth can't be disabled while AUTH or AUTH_ROUTES uses it")
+			}
+		}
+	}
+	if chain.index(MiddlewareRoute) < 0 {
+		for _, name := range routeDependents {
+			if chain.index(name) >= 0 {
+				return fmt.Errorf("route can't be disabled while %s depends on it", name)
+			}
+		}
+	}
+	return nil
+}
+
+// newCORS creates the cors middleware from the current config.
+func newCORS(cf *Config) *cors.Cors {
+	return cors.New(cors.Options{
+		// allow * by default
+		// in production we may want to be more picky, depending on whether
+		// we want to allow third parties to access this api from apps
+		// that we don't control.
+		AllowedOrigins: cf.GetStringArray("CORS_ORIGINS"),
+		// These are the basic REST methods; update if needed
+		AllowedMethods: cf.GetStringArray("CORS_METHODS"),
+		// You can turn this on for debugging
+		Debug: cf.GetFlag("CORS_DEBUG"),
+		// We don't currently need/use credentials. But that can change.
+		AllowCredentials: false,
+	})
+}
+
+// baseLogger finds the logrus Logger behind a FieldLogger, or nil.
+func baseLogger(l log.FieldLogger) *log.Logger {
+	switch t := l.(type) {
+	case *log.Logger:
+		return t
+	case *log.Entry:
+		return t.Logger
+	}
+	return nil
+}
+
+// setLogLevel sets the level of base, logging any problem to logger.
+func setLogLevel(base *log.Logger, logger log.FieldLogger, level string) {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		logger.WithError(err).Error("invalid LOG_LEVEL")
+		return
+	}
+	base.SetLevel(lvl)
+}
+
+// newOpenAPI creates the OpenAPI spec for svcs, titled from the config.
+func newOpenAPI(cf *Config, svcs []*boneful.Service) *OpenAPI {
+	title := cf.GetString("OPENAPI_TITLE")
+	if title == "" {
+		title = cf.GetString("rootpath")
+	}
+	doc := NewOpenAPI(svcs[0], title, cf.GetString("OPENAPI_VERSION"))
+	for _, svc := range svcs[1:] {
+		doc.AddService(svc)
+	}
+	return doc
+}
+
+// mount is a builder and the path its service is mounted at. The name is
+// empty for the single service set up by StandardSetup.
+type mount struct {
+	name    string
+	path    string
+	builder Builder
+}
+
+// buildAll builds the service for each mount.
+func buildAll(mounts []mount, logger *log.Entry) []*boneful.Service {
+	svcs := make([]*boneful.Service, len(mounts))
+	for i, m := range mounts {
+		l := logger
+		if l != nil && m.name != "" {
+			l = l.WithField("service", m.name)
+		}
+		svcs[i] = m.builder.Build(l, m.path)
+	}
+	return svcs
+}
+
 // StandardSetup is what should be called to set up the service before
 // running it. It returns a server, or possibly nil.
+// Unless RELOAD_ON_HUP is false, it reloads the config on SIGHUP; CORS
+// settings and LOG_LEVEL take effect without a restart, and TLS
+// certificates are reloaded too.
+// The server listens on LISTEN (or :port), or on sockets passed by
+// systemd, and if ADMIN_PORT is set, the health, config, metrics, and
+// pprof routes are served on a separate admin server instead. Serve and
+// Run start and stop them all together.
+// The API docs are served at <rootpath>/docs, merged with the docs of the
+// services in DOCS_SERVICES if there are any, and the OpenAPI spec at
+// <rootpath>/openapi.json.
+// Requests pass through a chain of standard middleware, which a Builder
+// that implements MiddlewareProvider can add to, and from which the
+// middleware in DISABLE_MIDDLEWARE are removed.
 func StandardSetup(cf *Config, builder Builder) *http.Server {
+	return standardSetup(cf, []mount{{path: cf.GetString("rootpath"), builder: builder}})
+}
+
+// StandardSetupMulti is like StandardSetup, but serves several services,
+// each mounted at <rootpath>/<name> and built with a logger tagged with
+// its name. The docs, OpenAPI spec, health checks, metrics, and auth
+// policies cover all of them.
+func StandardSetupMulti(cf *Config, builders map[string]Builder) *http.Server {
+	var names []string
+	for name := range builders {
+		if strings.Trim(name, "/") == "" {
+			log.Fatalf("service names must not be empty")
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		log.Fatalf("no services to set up")
+	}
+	sort.Strings(names)
+	mounts := make([]mount, len(names))
+	for i, name := range names {
+		mounts[i] = mount{
+			name:    name,
+			path:    path.Join(cf.GetString("rootpath"), name),
+			builder: builders[name],
+		}
+	}
+	return standardSetup(cf, mounts)
+}
+
+// standardSetup does the work of StandardSetup for one or more services.
+func standardSetup(cf *Config, mounts []mount) *http.Server {
 	docs := cf.GetString("docs")
 	if docs != "" {
 		var outf = os.Stdout
@@ -129,42 +315,212 @@ func StandardSetup(cf *Config, builder Builder) *http.Server {
 			if err != nil {
 				log.Fatalf("could not write docs to %s", docs)
 			}
-			return nil
+			defer outf.Close()
+		}
+		for _, svc := range buildAll(mounts, nil) {
+			svc.GenerateDocumentation(outf)
+		}
+		return nil
+	}
+	if spec := cf.GetString("openapi"); spec != "" {
+		var outf = os.Stdout
+		var err error
+		if spec != "-" {
+			outf, err = os.Create(spec)
+			if err != nil {
+				log.Fatalf("could not write OpenAPI spec to %s", spec)
+			}
+			defer outf.Close()
+		}
+		if err := newOpenAPI(cf, buildAll(mounts, nil)).Write(outf); err != nil {
+			log.Fatalf("could not write OpenAPI spec to %s: %s", spec, err)
 		}
-		svc := builder.Build(nil, cf.GetString("rootpath"))
-		svc.GenerateDocumentation(outf)
 		return nil
 	}
 
 	// create the logger
 	var hlog log.FieldLogger
-	if builder.GetLogger() != nil {
-		hlog = builder.GetLogger()
-	} else {
+	for _, m := range mounts {
+		if m.builder.GetLogger() != nil {
+			hlog = m.builder.GetLogger()
+			break
+		}
+	}
+	if hlog == nil {
+		// honeycomb reads its settings from the environment, so pass on
+		// the ones that came from elsewhere, like HONEYCOMB_KEY_FILE
+		if key := cf.GetSecret("HONEYCOMB_KEY"); !key.IsEmpty() {
+			os.Setenv("HONEYCOMB_KEY", key.Reveal())
+		}
+		os.Setenv("HONEYCOMB_DATASET", cf.GetString("HONEYCOMB_DATASET"))
 		hlog = honeycomb.Setup(log.New())
 	}
 	logger := hlog.WithFields(log.Fields{
 		"rootpath": cf.GetString("rootpath"),
 	})
-	// now create the service
-	svc := builder.Build(logger, cf.GetString("rootpath"))
-	// wrap it in logging middleware
-	logmux := LogMW(logger, svc.Mux())
-	// and then in cors
-	c := cors.New(cors.Options{
-		// allow * by default
-		// in production we may want to be more picky, depending on whether
-		// we want to allow third parties to access this api from apps
-		// that we don't control.
-		AllowedOrigins: cf.GetStringArray("CORS_ORIGINS"),
-		// These are the basic REST methods; update if needed
-		AllowedMethods: cf.GetStringArray("CORS_METHODS"),
-		// You can turn this on for debugging
-		Debug: cf.GetFlag("CORS_DEBUG"),
-		// We don't currently need/use credentials. But that can change.
-		AllowCredentials: false,
+	if base := baseLogger(hlog); base != nil {
+		setLogLevel(base, logger, cf.GetEnum("LOG_LEVEL"))
+		cf.OnChange("LOG_LEVEL", func(old, new interface{}) {
+			setLogLevel(base, logger, new.(string))
+		})
+	}
+	// now create the services
+	svcs := buildAll(mounts, logger)
+	// mount them alongside the standard admin routes
+	mux := http.NewServeMux()
+	routes := NewRouteTable(nil)
+	health := NewHealth(cf.GetDuration("HEALTH_TIMEOUT"))
+	var sds []ServiceDocs
+	for i, m := range mounts {
+		if m.name == "" {
+			mux.Handle("/", svcs[i].Mux())
+		} else {
+			mux.Handle(m.path, svcs[i].Mux())
+			mux.Handle(m.path+"/", svcs[i].Mux())
+		}
+		routes.AddService(svcs[i])
+		if hc, ok := m.builder.(HealthChecker); ok {
+			health.AddChecker(hc)
+		}
+		sds = append(sds, NewServiceDocs(svcs[i], m.path))
+	}
+	var adminPaths []string
+	admin := func(p string, h http.Handler) {
+		mux.Handle(p, h)
+		routes.Add(AnyMethod, p)
+		adminPaths = append(adminPaths, p)
+	}
+	// the health, config, metrics, and pprof routes go on a separate admin
+	// server if ADMIN_PORT is set
+	internal := admin
+	var adminMux *http.ServeMux
+	if cf.GetInt("ADMIN_PORT") != 0 {
+		adminMux = http.NewServeMux()
+		internal = adminMux.Handle
+		if cf.GetFlag("PPROF") {
+			adminMux.HandleFunc("/debug/pprof/", pprof.Index)
+			adminMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+			adminMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+			adminMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+			adminMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		}
+	}
+	if siblings := cf.GetStringArray("DOCS_SERVICES"); len(siblings) != 0 {
+		admin(path.Join(cf.GetString("rootpath"), "docs"), AggregateDocsHandler(sds, siblings, cf.GetDuration("DOCS_TIMEOUT"), cf.GetDuration("DOCS_CACHE_TTL")))
+	} else {
+		admin(path.Join(cf.GetString("rootpath"), "docs"), DocsHandler(sds...))
+	}
+	admin(path.Join(cf.GetString("rootpath"), "openapi.json"), OpenAPIHandler(newOpenAPI(cf, svcs)))
+	healthPaths := []string{
+		path.Join(cf.GetString("rootpath"), "health/live"),
+		path.Join(cf.GetString("rootpath"), "health/ready"),
+	}
+	internal(healthPaths[0], health.LiveHandler())
+	internal(healthPaths[1], health.ReadyHandler())
+	if cf.GetFlag("CONFIG_ENDPOINT") {
+		internal(path.Join(cf.GetString("rootpath"), "config"), cf.DumpHandler())
+	}
+	if p := cf.GetString("METRICS_PATH"); p != "" {
+		internal(p, MetricsHandler(Registry))
+	}
+	// health checks don't need auth unless AUTH_ROUTES says so; the other
+	// admin routes follow the default policy
+	builders := make([]Builder, len(mounts))
+	for i, m := range mounts {
+		builders[i] = m.builder
+	}
+	auth, policies, err := AuthFromConfig(cf, builders...)
+	if err == nil {
+		err = policies.checkRoutes(routes)
+	}
+	if err != nil {
+		logger.WithError(err).Fatal("could not set up auth")
+	}
+	for _, p := range healthPaths {
+		if _, ok := cf.GetStringMap("AUTH_ROUTES")[p]; !ok {
+			policies.Routes[p] = AuthNone
+		}
+	}
+	// and they aren't rate limited unless RATE_LIMIT_ROUTES says so
+	limiter, err := RateLimiterFromConfig(cf)
+	if err != nil {
+		logger.WithError(err).Fatal("could not set up rate limits")
+	}
+	failures, err := AuthFailureLimiterFromConfig(cf)
+	if err != nil {
+		logger.WithError(err).Fatal("could not set up rate limits")
+	}
+	deadlines, err := DeadlinesFromConfig(cf)
+	if err != nil {
+		logger.WithError(err).Fatal("could not set up request deadlines")
+	}
+	if limiter != nil {
+		for _, p := range adminPaths {
+			if _, ok := limiter.Routes[p]; !ok {
+				limiter.Routes[p] = Limit{}
+			}
+		}
+	}
+	accessLog, err := AccessLogFromConfig(cf, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("could not set up the access log")
+	}
+	metrics := DefaultMetrics()
+	chain := &Chain{}
+	// cors is rebuilt if its config is reloaded
+	chain.Append(MiddlewareCORS, func(h http.Handler) http.Handler {
+		var corsmux atomic.Value
+		corsmux.Store(newCORS(cf).Handler(h))
+		for _, name := range []string{"CORS_ORIGINS", "CORS_METHODS", "CORS_DEBUG"} {
+			cf.OnChange(name, func(old, new interface{}) {
+				corsmux.Store(newCORS(cf).Handler(h))
+			})
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			corsmux.Load().(http.Handler).ServeHTTP(w, r)
+		})
+	})
+	// request IDs are needed by logging, client identity by auth, and route
+	// matching by auth, rate limits, deadlines, and metrics
+	chain.Append(MiddlewareRequestID, func(h http.Handler) http.Handler { return RequestIDMW(logger, h) })
+	chain.Append(MiddlewareClientIdentity, func(h http.Handler) http.Handler { return ClientIdentityMW(h) })
+	chain.Append(MiddlewareRoute, func(h http.Handler) http.Handler { return RouteMW(routes, h) })
+	chain.Append(MiddlewareMetrics, func(h http.Handler) http.Handler { return metrics.MetricsMW(h) })
+	chain.Append(MiddlewareLog, func(h http.Handler) http.Handler { return AccessLogMW(accessLog, h) })
+	chain.Append(MiddlewareRecover, func(h http.Handler) http.Handler { return RecoverMW(metrics.Panics, h) })
+	// failed authentications are limited by IP, since the rate limit
+	// inside auth can't see them
+	chain.Append(MiddlewareAuth, func(h http.Handler) http.Handler {
+		if failures == nil || len(auth) == 0 {
+			return AuthMW(auth, policies, h)
+		}
+		return AuthFailureLimitMW(failures, AuthMW(auth, policies, h))
+	})
+	chain.Append(MiddlewareRateLimit, func(h http.Handler) http.Handler {
+		if limiter == nil {
+			return h
+		}
+		return RateLimitMW(limiter, h)
+	})
+	chain.Append(MiddlewareDeadline, func(h http.Handler) http.Handler { return DeadlineMW(deadlines, h) })
+	chain.Append(MiddlewareThrottle, func(h http.Handler) http.Handler {
+		return ThrottleMW(cf.GetByteSize("THROTTLE_RATE"), h)
 	})
-	handler := c.Handler(logmux)
+	for _, m := range mounts {
+		if mp, ok := m.builder.(MiddlewareProvider); ok {
+			if err := mp.ConfigureMiddleware(chain); err != nil {
+				logger.WithError(err).Fatal("could not set up middleware")
+			}
+		}
+	}
+	if err := disableMiddleware(chain, cf.GetStringArray("DISABLE_MIDDLEWARE"), policies); err != nil {
+		logger.WithError(err).Fatal("DISABLE_MIDDLEWARE")
+	}
+	handler := chain.Then(mux)
+
+	if cf.GetFlag("RELOAD_ON_HUP") {
+		WatchReload(cf, logger)
+	}
 
 	// now create the server
 	server := &http.Server{
@@ -173,6 +529,38 @@ func StandardSetup(cf *Config, builder Builder) *http.Server {
 		ReadTimeout:  cf.GetDuration("READ_TIMEOUT"),
 		WriteTimeout: cf.GetDuration("WRITE_TIMEOUT"),
 	}
-	logger.WithField("port", cf.GetInt("port")).Info("server listening")
+	if rate := cf.GetByteSize("THROTTLE_RATE"); rate != 0 {
+		server.ConnContext = ThrottleConnContext(rate)
+	}
+	tlsConfig, certs, err := TLSFromConfig(cf)
+	if err != nil {
+		logger.WithError(err).Fatal("could not set up TLS")
+	}
+	if tlsConfig != nil {
+		server.TLSConfig = tlsConfig
+		if cf.GetFlag("RELOAD_ON_HUP") {
+			WatchCertReload(certs, logger)
+		}
+	}
+
+	// the admin server is internal, so it gets neither TLS nor auth, and
+	// no write timeout, since pprof profiles take as long as they're asked
+	var adminServer *http.Server
+	if adminMux != nil {
+		adminServer = &http.Server{
+			Addr:        fmt.Sprintf(":%v", cf.GetInt("ADMIN_PORT")),
+			Handler:     RequestIDMW(logger, AccessLogMW(accessLog, RecoverMW(metrics.Panics, adminMux))),
+			ReadTimeout: cf.GetDuration("READ_TIMEOUT"),
+		}
+	}
+	setupBindings.Store(server, func() ([]Binding, error) {
+		return bindingsFromConfig(cf, server, adminServer)
+	})
+	logger.WithFields(log.Fields{
+		"port":      cf.GetInt("port"),
+		"listen":    cf.GetStringArray("LISTEN"),
+		"adminPort": cf.GetInt("ADMIN_PORT"),
+		"tls":       tlsConfig != nil,
+	}).Info("server listening")
 	return server
 }
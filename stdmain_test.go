@@ -0,0 +1,58 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"net/http"
+	"testing"
+)
+
+func standardChain() *Chain {
+	chain := &Chain{}
+	nop := func(h http.Handler) http.Handler { return h }
+	for _, name := range []string{
+		MiddlewareCORS, MiddlewareRequestID, MiddlewareClientIdentity,
+		MiddlewareRoute, MiddlewareMetrics, MiddlewareLog, MiddlewareRecover,
+		MiddlewareAuth, MiddlewareRateLimit, MiddlewareDeadline, MiddlewareThrottle,
+	} {
+		chain.Append(name, nop)
+	}
+	return chain
+}
+
+func TestDisableMiddleware(t *testing.T) {
+	open := AuthPolicies{Default: AuthNone, Routes: map[string]AuthPolicy{"/health/live": AuthNone}}
+	required := AuthPolicies{Default: AuthRequired}
+	oneRoute := AuthPolicies{Default: AuthNone, Routes: map[string]AuthPolicy{"/secret": AuthRequired}}
+	tests := []struct {
+		name     string
+		disable  []string
+		policies AuthPolicies
+		ok       bool
+	}{
+		{"nothing", nil, required, true},
+		{"safe ones", []string{"cors", "throttle"}, required, true},
+		{"unknown", []string{"gzip"}, open, false},
+		{"auth when unused", []string{"auth"}, open, true},
+		{"auth when required", []string{"auth"}, required, false},
+		{"auth when a route requires it", []string{"auth"}, oneRoute, false},
+		{"route alone", []string{"route"}, open, false},
+		{"route and its dependents", []string{"route", "auth", "ratelimit", "deadline"}, open, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := disableMiddleware(standardChain(), tt.disable, tt.policies)
+			if (err == nil) != tt.ok {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// byteBucket is a token bucket of bytes, refilled at rate bytes per
+// second up to one second's worth.
+type byteBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newByteBucket(rate ByteSize) *byteBucket {
+	return &byteBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait blocks until n bytes may be sent, or ctx is done.
+func (b *byteBucket) wait(ctx context.Context, n int) error {
+	b.mutex.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	delay := seconds(-b.tokens / b.rate)
+	b.mutex.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type throttleKey struct{}
+
+// ThrottleConnContext returns a function for http.Server.ConnContext that
+// gives each connection its own bandwidth budget of rate bytes per second,
+// shared by every request on it. Without it, ThrottleMW limits each
+// response separately.
+func ThrottleConnContext(rate ByteSize) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, throttleKey{}, newByteBucket(rate))
+	}
+}
+
+// ThrottleWriter proxies http.ResponseWriter and limits the rate at which
+// the response body is written. Like LogWriter, it forwards http.Flusher,
+// http.Hijacker, http.Pusher, and http.CloseNotifier; it doesn't forward
+// io.ReaderFrom, which would bypass the limit. A hijacked connection isn't
+// throttled.
+type ThrottleWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	bucket *byteBucket
+}
+
+// Write proxies http.ResponseWriter.Write, sending b in chunks no larger
+// than the per-second budget and waiting between them as needed.
+func (w *ThrottleWriter) Write(b []byte) (int, error) {
+	chunk := int(w.bucket.rate)
+	if chunk < 1 {
+		chunk = 1
+	}
+	written := 0
+	for len(b) > 0 {
+		n := chunk
+		if n > len(b) {
+			n = len(b)
+		}
+		if err := w.bucket.wait(w.ctx, n); err != nil {
+			return written, err
+		}
+		m, err := w.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Flush implements http.Flusher for ThrottleWriter. It does nothing if the
+// underlying writer can't flush.
+func (w *ThrottleWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker for ThrottleWriter. It returns
+// http.ErrNotSupported if the underlying writer can't be hijacked.
+func (w *ThrottleWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Push implements http.Pusher for ThrottleWriter. It returns
+// http.ErrNotSupported if the underlying writer can't push.
+func (w *ThrottleWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// CloseNotify implements http.CloseNotifier for ThrottleWriter. If the
+// underlying writer isn't a CloseNotifier, the channel never receives.
+func (w *ThrottleWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *ThrottleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// ThrottleMW wraps a handler and limits response bodies to rate bytes per
+// second, per connection if ThrottleConnContext is installed on the
+// server and per response otherwise. A rate of 0 means no limit.
+func ThrottleMW(rate ByteSize, handler http.Handler) http.Handler {
+	if rate == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := r.Context().Value(throttleKey{}).(*byteBucket)
+		if !ok {
+			b = newByteBucket(rate)
+		}
+		handler.ServeHTTP(&ThrottleWriter{ResponseWriter: w, ctx: r.Context(), bucket: b}, r)
+	})
+}
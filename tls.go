@@ -0,0 +1,286 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tlsVersions maps TLS_MIN_VERSION values to versions.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// modernCiphers are the TLS 1.2 cipher suites allowed by the "modern"
+// policy: forward secrecy and AEAD only. TLS 1.3 suites aren't
+// configurable, and are all allowed.
+var modernCiphers = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// tlsClientAuth maps TLS_CLIENT_AUTH values to client auth types.
+var tlsClientAuth = map[string]tls.ClientAuthType{
+	"none":     tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"required": tls.RequireAndVerifyClientCert,
+}
+
+// addTLSConfig adds the config items used by TLSFromConfig.
+func addTLSConfig(cf *Config) {
+	cf.AddPath("TLS_CERT_FILE", "", true, "PEM certificate (chain) to serve HTTPS with; HTTP if empty")
+	cf.AddPath("TLS_KEY_FILE", "", true, "PEM private key for TLS_CERT_FILE")
+	cf.AddEnum("TLS_MIN_VERSION", "1.2", "1.2", "1.3")
+	cf.Describe("TLS_MIN_VERSION", "minimum TLS version to accept")
+	cf.AddEnum("TLS_CIPHERS", "default", "default", "modern")
+	cf.Describe("TLS_CIPHERS", "TLS 1.2 cipher policy: Go's defaults, or only forward-secret AEAD suites")
+	cf.AddPath("TLS_CLIENT_CA_FILE", "", true, "PEM bundle of CAs that sign client certificates")
+	cf.AddEnum("TLS_CLIENT_AUTH", "none", "none", "optional", "required")
+	cf.Describe("TLS_CLIENT_AUTH", "whether clients must present a certificate signed by TLS_CLIENT_CA_FILE")
+}
+
+// CertReloader serves a certificate and client CA bundle that can be
+// reloaded from their files while the server is running.
+type CertReloader struct {
+	certFile, keyFile, caFile string
+	cert                      atomic.Value // *tls.Certificate
+	clientCAs                 atomic.Value // *x509.CertPool
+
+	// base is the config passed to TLSConfig, and forClient is built from
+	// it on every Reload, so that each handshake shares one config
+	mutex     sync.Mutex
+	base      *tls.Config
+	forClient atomic.Value // *tls.Config
+}
+
+// NewCertReloader loads the certificate and key, and the client CA bundle
+// if caFile isn't empty.
+func NewCertReloader(certFile, keyFile, caFile string) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// loadCertPool reads a PEM bundle of CA certificates.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return pool, nil
+}
+
+// Reload reads the files again. If any of them can't be loaded, it
+// returns an error and keeps serving the previous ones.
+func (cr *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if cr.caFile != "" {
+		pool, err = loadCertPool(cr.caFile)
+		if err != nil {
+			return err
+		}
+	}
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	cr.cert.Store(&cert)
+	cr.clientCAs.Store(pool)
+	cr.rebuild()
+	return nil
+}
+
+// rebuild makes the config used for handshakes from base and the current
+// client CAs. The caller must hold the mutex.
+func (cr *CertReloader) rebuild() {
+	if cr.base == nil {
+		return
+	}
+	c := cr.base.Clone()
+	c.GetCertificate = cr.GetCertificate
+	c.ClientCAs = cr.clientCAs.Load().(*x509.CertPool)
+	cr.forClient.Store(c)
+}
+
+// GetCertificate is for tls.Config.GetCertificate.
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.cert.Load().(*tls.Certificate), nil
+}
+
+// TLSConfig returns a server config based on base that uses the current
+// certificate and client CAs for every handshake. If base has no
+// NextProtos, it offers HTTP/2 and HTTP/1.1, since the config that
+// net/http adds h2 to isn't the one used for the handshake.
+func (cr *CertReloader) TLSConfig(base *tls.Config) *tls.Config {
+	base = base.Clone()
+	if len(base.NextProtos) == 0 {
+		base.NextProtos = []string{"h2", "http/1.1"}
+	}
+	cr.mutex.Lock()
+	cr.base = base
+	cr.rebuild()
+	cr.mutex.Unlock()
+
+	cfg := base.Clone()
+	cfg.GetCertificate = cr.GetCertificate
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return cr.forClient.Load().(*tls.Config), nil
+	}
+	return cfg
+}
+
+// WatchCertReload reloads the certificates whenever the process receives
+// SIGHUP, logging any failure to logger.
+func WatchCertReload(cr *CertReloader, logger log.FieldLogger) {
+	go func() {
+		sigchan := make(chan os.Signal, 1)
+		signal.Notify(sigchan, syscall.SIGHUP)
+		for range sigchan {
+			if err := cr.Reload(); err != nil {
+				logger.WithError(err).Error("certificate reload failed; keeping current certificates")
+				continue
+			}
+			logger.Info("certificates reloaded")
+		}
+	}()
+}
+
+// TLSFromConfig creates the server's TLS config and its CertReloader from
+// the TLS_* items, or returns nils if TLS_CERT_FILE isn't set.
+func TLSFromConfig(cf *Config) (*tls.Config, *CertReloader, error) {
+	certFile, keyFile := cf.GetPath("TLS_CERT_FILE"), cf.GetPath("TLS_KEY_FILE")
+	caFile := cf.GetPath("TLS_CLIENT_CA_FILE")
+	clientAuth := tlsClientAuth[cf.GetEnum("TLS_CLIENT_AUTH")]
+	if certFile == "" {
+		if keyFile != "" || caFile != "" || clientAuth != tls.NoClientCert {
+			return nil, nil, errors.New("TLS settings need TLS_CERT_FILE")
+		}
+		return nil, nil, nil
+	}
+	if keyFile == "" {
+		return nil, nil, errors.New("TLS_CERT_FILE needs TLS_KEY_FILE")
+	}
+	if clientAuth != tls.NoClientCert && caFile == "" {
+		return nil, nil, errors.New("TLS_CLIENT_AUTH needs TLS_CLIENT_CA_FILE")
+	}
+	cr, err := NewCertReloader(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	base := &tls.Config{
+		MinVersion: tlsVersions[cf.GetEnum("TLS_MIN_VERSION")],
+		ClientAuth: clientAuth,
+	}
+	if cf.GetEnum("TLS_CIPHERS") == "modern" {
+		base.CipherSuites = modernCiphers
+	}
+	return cr.TLSConfig(base), cr, nil
+}
+
+// ClientIdentity is the identity in a verified client certificate.
+type ClientIdentity struct {
+	CommonName string
+	DNSNames   []string
+	URIs       []string
+	Emails     []string
+	Serial     string
+	Issuer     string
+	// Cert is the client's leaf certificate.
+	Cert *x509.Certificate
+}
+
+type clientIdentityKey struct{}
+
+// verifiedClientIdentity returns the identity in the request's verified
+// client certificate, or nil if there isn't one.
+func verifiedClientIdentity(r *http.Request) *ClientIdentity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	id := &ClientIdentity{
+		CommonName: cert.Subject.CommonName,
+		DNSNames:   cert.DNSNames,
+		Emails:     cert.EmailAddresses,
+		Serial:     cert.SerialNumber.String(),
+		Issuer:     cert.Issuer.String(),
+		Cert:       cert,
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+	return id
+}
+
+// ClientIdentityMW wraps a handler and stores the identity in the verified
+// client certificate, if any, in the request context.
+func ClientIdentityMW(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if id := verifiedClientIdentity(r); id != nil {
+			r = r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, id))
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// ClientIdentityFromContext returns the client identity stored in ctx, or nil.
+func ClientIdentityFromContext(ctx context.Context) *ClientIdentity {
+	id, _ := ctx.Value(clientIdentityKey{}).(*ClientIdentity)
+	return id
+}
+
+// GetClientIdentity returns the identity in the request's verified client
+// certificate, or nil if the client didn't present one.
+func GetClientIdentity(r *http.Request) *ClientIdentity {
+	return ClientIdentityFromContext(r.Context())
+}
+
+// ClientCertAuth is an Authenticator for verified client certificates.
+// The principal is the certificate's common name.
+type ClientCertAuth struct{}
+
+// Authenticate implements Authenticator.
+func (ClientCertAuth) Authenticate(r *http.Request) (*Principal, error) {
+	id := verifiedClientIdentity(r)
+	if id == nil {
+		return nil, ErrNoCredentials
+	}
+	if id.CommonName == "" {
+		return nil, errors.New("client certificate has no common name")
+	}
+	return &Principal{ID: id.CommonName, Method: "mtls"}, nil
+}
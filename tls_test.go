@@ -0,0 +1,252 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// tlsFixture is a CA with a server certificate written to a temp dir.
+type tlsFixture struct {
+	dir               string
+	ca                *TestCA
+	caFile            string
+	certFile, keyFile string
+}
+
+func newTLSFixture(t *testing.T) *tlsFixture {
+	t.Helper()
+	f := &tlsFixture{dir: t.TempDir()}
+	var err error
+	if f.ca, err = NewTestCA(); err != nil {
+		t.Fatal(err)
+	}
+	if f.caFile, err = f.ca.WriteFile(f.dir, "ca"); err != nil {
+		t.Fatal(err)
+	}
+	server, err := f.ca.Issue("server", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.certFile, f.keyFile, err = server.WriteFiles(f.dir, "server"); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// serveTLS serves handler over TLS with the config built from args, and
+// returns its URL and CertReloader.
+func serveTLS(t *testing.T, args []string, handler http.Handler) (string, *CertReloader) {
+	t.Helper()
+	cf := NewConfig()
+	addTLSConfig(cf)
+	if err := cf.ParseArgsE(args); err != nil {
+		t.Fatal(err)
+	}
+	cfg, cr, err := TLSFromConfig(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler, TLSConfig: cfg}
+	go server.ServeTLS(l, "", "")
+	t.Cleanup(func() { server.Close() })
+	return "https://" + l.Addr().String() + "/", cr
+}
+
+// tlsGet makes a request on a new connection with the given client config,
+// and returns the body.
+func tlsGet(url string, cfg *tls.Config) (string, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestTLS(t *testing.T) {
+	f := newTLSFixture(t)
+	url, _ := serveTLS(t, []string{
+		"--TLS_CERT_FILE=" + f.certFile,
+		"--TLS_KEY_FILE=" + f.keyFile,
+		"--TLS_MIN_VERSION=1.3",
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+
+	trusting, err := f.ca.ClientTLSConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := tlsGet(url, trusting); err != nil || body != "hello" {
+		t.Errorf("got %q, %v", body, err)
+	}
+	if _, err := tlsGet(url, &tls.Config{}); err == nil {
+		t.Error("a client that doesn't trust the CA connected")
+	}
+	old := trusting.Clone()
+	old.MaxVersion = tls.VersionTLS12
+	if _, err := tlsGet(url, old); err == nil {
+		t.Error("a TLS 1.2 client connected despite TLS_MIN_VERSION")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	f := newTLSFixture(t)
+	handler := ClientIdentityMW(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := GetClientIdentity(r)
+		p, err := ClientCertAuth{}.Authenticate(r)
+		if id == nil || err != nil || p.ID != id.CommonName {
+			http.Error(w, "no identity", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(id.CommonName))
+	}))
+	url, _ := serveTLS(t, []string{
+		"--TLS_CERT_FILE=" + f.certFile,
+		"--TLS_KEY_FILE=" + f.keyFile,
+		"--TLS_CLIENT_CA_FILE=" + f.caFile,
+		"--TLS_CLIENT_AUTH=required",
+	}, handler)
+
+	client, err := f.ca.Issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withCert, err := f.ca.ClientTLSConfig(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := tlsGet(url, withCert); err != nil || body != "alice" {
+		t.Errorf("got %q, %v", body, err)
+	}
+
+	withoutCert, err := f.ca.ClientTLSConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tlsGet(url, withoutCert); err == nil {
+		t.Error("a client without a certificate connected")
+	}
+
+	otherCA, err := NewTestCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger, err := otherCA.Issue("mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withStranger, err := f.ca.ClientTLSConfig(stranger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tlsGet(url, withStranger); err == nil {
+		t.Error("a client with a certificate from another CA connected")
+	}
+}
+
+func TestCertReload(t *testing.T) {
+	f := newTLSFixture(t)
+	url, cr := serveTLS(t, []string{
+		"--TLS_CERT_FILE=" + f.certFile,
+		"--TLS_KEY_FILE=" + f.keyFile,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	oldClient, err := f.ca.ClientTLSConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a broken file is rejected, and the old certificate is kept
+	if err := ioutil.WriteFile(f.certFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cr.Reload(); err == nil {
+		t.Error("reloaded a broken certificate")
+	}
+	if _, err := tlsGet(url, oldClient); err != nil {
+		t.Errorf("old certificate was dropped: %v", err)
+	}
+
+	// a certificate from a new CA replaces it
+	newCA, err := NewTestCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := newCA.Issue("server", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := server.WriteFiles(f.dir, "server"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cr.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	newClient, err := newCA.ClientTLSConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tlsGet(url, newClient); err != nil {
+		t.Errorf("new certificate isn't served: %v", err)
+	}
+	if _, err := tlsGet(url, oldClient); err == nil {
+		t.Error("old certificate is still served")
+	}
+}
+
+func TestTLSHTTP2(t *testing.T) {
+	f := newTLSFixture(t)
+	url, _ := serveTLS(t, []string{
+		"--TLS_CERT_FILE=" + f.certFile,
+		"--TLS_KEY_FILE=" + f.keyFile,
+		"--TLS_CLIENT_CA_FILE=" + f.caFile,
+		"--TLS_CLIENT_AUTH=optional",
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	cfg, err := f.ca.ClientTLSConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+
+	var resumed bool
+	for i := 0; i < 2; i++ {
+		// a new transport each time, so that each request makes a new
+		// connection
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, ForceAttemptHTTP2: true}}
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		client.CloseIdleConnections()
+		if err != nil || string(body) != "HTTP/2.0" {
+			t.Errorf("got %q, %v", body, err)
+		}
+		resumed = resp.TLS.DidResume
+	}
+	if !resumed {
+		t.Error("the second connection didn't resume the session")
+	}
+}
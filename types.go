@@ -0,0 +1,286 @@
+package rest
+
+// ----- ---- --- -- -
+// Copyright 2019, 2020 The Axiom Foundation. All Rights Reserved.
+//
+// Licensed under the Apache License 2.0 (the "License").  You may not use
+// this file except in compliance with the License.  You can obtain a copy
+// in the file LICENSE in the source distribution or at
+// https://www.apache.org/licenses/LICENSE-2.0.txt
+// - -- --- ---- -----
+
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a number of bytes that can be parsed from and formatted as
+// a human-readable size like "10MB". Decimal units (KB, MB, GB, TB) are
+// powers of 1000 and binary units (KiB, MiB, GiB, TiB) are powers of 1024.
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	// longest suffixes first, so that "MiB" isn't mistaken for "B"
+	{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+	{"T", 1e12}, {"G", 1e9}, {"M", 1e6}, {"K", 1e3},
+	{"B", 1},
+}
+
+// ParseByteSize parses a size like "10MB", "1.5GiB", or "512".
+func ParseByteSize(s string) (ByteSize, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	mult := ByteSize(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(t, u.suffix) {
+			t = strings.TrimSpace(strings.TrimSuffix(t, u.suffix))
+			mult = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(t, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a size like 512, 10KB, or 1.5GiB")
+	}
+	return ByteSize(n * float64(mult)), nil
+}
+
+// String formats the size with the largest unit that divides it evenly,
+// so that it round-trips through ParseByteSize.
+func (b ByteSize) String() string {
+	for _, u := range byteUnits[:8] {
+		if b != 0 && b%u.size == 0 {
+			suffix := u.suffix
+			if len(suffix) == 3 {
+				suffix = suffix[:1] + "iB"
+			}
+			return fmt.Sprintf("%d%s", b/u.size, suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
+
+// parseURL parses an absolute URL; relative URLs are almost certainly
+// a configuration mistake.
+func parseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("expected an absolute URL like http://host:port/path")
+	}
+	return u, nil
+}
+
+// parseStringMap parses "k=v,k2=v2" into a map.
+func parseStringMap(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return m, nil
+}
+
+// formatStringMap formats a map as "k=v,k2=v2" with the keys sorted.
+func formatStringMap(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + m[k]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// checkItem enforces the constraints that some item types carry beyond
+// their basic syntax.
+func checkItem(ag ConfigItem, v interface{}) error {
+	switch ag.Type {
+	case "enum":
+		for _, a := range ag.Allowed {
+			if v.(string) == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("expected one of %s", strings.Join(ag.Allowed, ", "))
+	case "path":
+		if ag.MustExist && v.(string) != "" {
+			if _, err := os.Stat(v.(string)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AddFloat adds a config element that is a floating-point number with its default.
+// An optional description is shown in the --help output.
+func (cf *Config) AddFloat(name string, def float64, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "float", Default: def, Help: joinHelp(help)}
+}
+
+// AddInt64 adds a config element that is a 64-bit integer with its default.
+// An optional description is shown in the --help output.
+func (cf *Config) AddInt64(name string, def int64, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "int64", Default: def, Help: joinHelp(help)}
+}
+
+// AddUint adds a config element that is an unsigned integer with its default.
+// An optional description is shown in the --help output.
+func (cf *Config) AddUint(name string, def uint, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "uint", Default: def, Help: joinHelp(help)}
+}
+
+// AddURL adds a config element that is an absolute URL with a default value.
+// The URL is specified as a string (which may be empty) and is returned as
+// a *url.URL.
+// An optional description is shown in the --help output.
+func (cf *Config) AddURL(name string, def string, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "url", Default: def, Help: joinHelp(help)}
+}
+
+// AddEnum adds a config element that is a string that must be one of the
+// allowed values. Since the allowed values are variadic, use Describe to
+// give it a description.
+func (cf *Config) AddEnum(name string, def string, allowed ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "enum", Default: def, Allowed: allowed}
+}
+
+// AddByteSize adds a config element that is a size in bytes with a default
+// value. The size is specified as a string like "10MB" and is returned as
+// a ByteSize.
+// An optional description is shown in the --help output.
+func (cf *Config) AddByteSize(name string, def string, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "bytesize", Default: def, Help: joinHelp(help)}
+}
+
+// AddStringMap adds a config element that is a map of strings to strings
+// with a default value. The map is specified as a string like "k=v,k2=v2"
+// and is returned as a map[string]string.
+// An optional description is shown in the --help output.
+func (cf *Config) AddStringMap(name string, def string, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "map", Default: def, Help: joinHelp(help)}
+}
+
+// AddPath adds a config element that is a filesystem path with a default
+// value. If mustExist is true, loading fails if the path doesn't exist.
+// An optional description is shown in the --help output.
+func (cf *Config) AddPath(name string, def string, mustExist bool, help ...string) {
+	(*cf)[clean(name)] = ConfigItem{Name: name, Type: "path", Default: def, MustExist: mustExist, Help: joinHelp(help)}
+}
+
+// GetFloat retrieves a floating-point number from the config, or 0 if not found.
+func (cf *Config) GetFloat(name string) float64 {
+	v, ok := cf.Get(name)
+	if !ok {
+		return 0
+	}
+	return v.(float64)
+}
+
+// GetInt64 retrieves a 64-bit integer from the config, or 0 if not found.
+func (cf *Config) GetInt64(name string) int64 {
+	v, ok := cf.Get(name)
+	if !ok {
+		return 0
+	}
+	return v.(int64)
+}
+
+// GetUint retrieves an unsigned integer from the config, or 0 if not found.
+func (cf *Config) GetUint(name string) uint {
+	v, ok := cf.Get(name)
+	if !ok {
+		return 0
+	}
+	return v.(uint)
+}
+
+// GetURL retrieves a URL from the config, or nil if not found or empty.
+func (cf *Config) GetURL(name string) *url.URL {
+	v, ok := cf.Get(name)
+	if !ok {
+		return nil
+	}
+	switch t := v.(type) {
+	case string:
+		u, _ := parseURL(t)
+		return u
+	case *url.URL:
+		return t
+	default:
+		return nil
+	}
+}
+
+// GetEnum retrieves an enumerated string from the config, or "" if not found.
+func (cf *Config) GetEnum(name string) string {
+	v, ok := cf.Get(name)
+	if !ok {
+		return ""
+	}
+	return v.(string)
+}
+
+// GetByteSize retrieves a size in bytes from the config, or 0 if not found.
+func (cf *Config) GetByteSize(name string) ByteSize {
+	v, ok := cf.Get(name)
+	if !ok {
+		return 0
+	}
+	switch t := v.(type) {
+	case string:
+		b, _ := ParseByteSize(t)
+		return b
+	case ByteSize:
+		return t
+	default:
+		return 0
+	}
+}
+
+// GetStringMap retrieves a map of strings from the config, or an empty map
+// if not found.
+func (cf *Config) GetStringMap(name string) map[string]string {
+	v, ok := cf.Get(name)
+	if !ok {
+		return map[string]string{}
+	}
+	switch t := v.(type) {
+	case string:
+		m, _ := parseStringMap(t)
+		return m
+	case map[string]string:
+		return t
+	default:
+		return map[string]string{}
+	}
+}
+
+// GetPath retrieves a filesystem path from the config, or "" if not found.
+func (cf *Config) GetPath(name string) string {
+	v, ok := cf.Get(name)
+	if !ok {
+		return ""
+	}
+	return v.(string)
+}